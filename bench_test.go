@@ -75,6 +75,23 @@ func buildRegexHost(n int, withToHost bool, code int) *redir.Redirector {
 	return r
 }
 
+func buildSectionRegexHost(n int, code int) *redir.Redirector {
+	hb := redir.HostBlock{
+		Pattern: "bench.example",
+		ToHost:  "success.example",
+		Status:  code,
+	}
+	for i := 0; i < n; i++ {
+		hb.Regex = append(hb.Regex, redir.RegexRule{
+			Pattern: `^/legacy/section` + strconv.Itoa(i) + `/([a-z0-9-]+)\.html$`,
+			To:      "/s/" + strconv.Itoa(i) + "/$1",
+		})
+	}
+	r := &redir.Redirector{DefaultCode: code, Hosts: []redir.HostBlock{hb}}
+	_ = r.Provision(caddy.Context{})
+	return r
+}
+
 func BenchmarkExact_Hit_1e3(b *testing.B) {
 	r := buildExactHost(1000, true, 308)
 	req := httptest.NewRequest("GET", "http://bench.example/e500", nil)
@@ -124,6 +141,47 @@ func BenchmarkPrefix_Miss_1e3(b *testing.B) {
 	}
 }
 
+func BenchmarkPrefix_Hit_1e5(b *testing.B) {
+	hb := redir.HostBlock{Pattern: "bench.example", ToHost: "success.example"}
+	for i := 0; i < 100000; i++ {
+		hb.Prefix = append(hb.Prefix, redir.PrefixRule{
+			From: "/c/" + strconv.Itoa(i) + "/",
+			To:   "/n/" + strconv.Itoa(i) + "/",
+		})
+	}
+	r := &redir.Redirector{DefaultCode: 308, Hosts: []redir.HostBlock{hb}}
+	_ = r.Provision(caddy.Context{})
+	req := httptest.NewRequest("GET", "http://bench.example/c/54321/rest", nil)
+	req.Host = "bench.example"
+	next := benchNext{}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = r.ServeHTTP(httptest.NewRecorder(), req, next)
+	}
+}
+
+func BenchmarkHost_Wildcard_1e4(b *testing.B) {
+	hosts := make([]redir.HostBlock, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		hosts = append(hosts, redir.HostBlock{
+			Pattern: "*.site" + strconv.Itoa(i) + ".example",
+			ToHost:  "success.example",
+			Exact:   map[string]string{"/p": "/q"},
+		})
+	}
+	r := &redir.Redirector{DefaultCode: 308, Hosts: hosts}
+	_ = r.Provision(caddy.Context{})
+	req := httptest.NewRequest("GET", "http://www.site9999.example/p", nil)
+	req.Host = "www.site9999.example"
+	next := benchNext{}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = r.ServeHTTP(httptest.NewRecorder(), req, next)
+	}
+}
+
 func BenchmarkRegex_Hit_1e2(b *testing.B) {
 	r := buildRegexHost(100, true, 308)
 	req := httptest.NewRequest("GET", "http://bench.example/u/123/post", nil)
@@ -147,3 +205,27 @@ func BenchmarkRegex_Miss_1e2(b *testing.B) {
 		_ = r.ServeHTTP(httptest.NewRecorder(), req, next)
 	}
 }
+
+func BenchmarkRegex_Prefiltered_Hit_1e3(b *testing.B) {
+	r := buildSectionRegexHost(1000, 308)
+	req := httptest.NewRequest("GET", "http://bench.example/legacy/section999/some-post.html", nil)
+	req.Host = "bench.example"
+	next := benchNext{}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = r.ServeHTTP(httptest.NewRecorder(), req, next)
+	}
+}
+
+func BenchmarkRegex_Prefiltered_Miss_1e3(b *testing.B) {
+	r := buildSectionRegexHost(1000, 308)
+	req := httptest.NewRequest("GET", "http://bench.example/legacy/archive/some-post.html", nil)
+	req.Host = "bench.example"
+	next := benchNext{}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = r.ServeHTTP(httptest.NewRecorder(), req, next)
+	}
+}
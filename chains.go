@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// chainHop is an exact rule taken on the way from a requested URL to its final
+// destination.
+type chainHop struct {
+	block *compiledHostBlock
+	from  string
+	rule  *compiledRule
+}
+
+func (h chainHop) String() string {
+	return h.block.pattern + h.from
+}
+
+// chainEnd is where a hop lands. host stays empty while the chain doesn't
+// leave the requested host; scheme is only known after an absolute target.
+type chainEnd struct {
+	scheme string
+	host   string
+	target string
+}
+
+// resolveChains follows every exact rule with a fixed target through the exact
+// rules of all host blocks. A chain that comes back to one of its rules fails
+// with the rules of the loop; with flatten set, the rules of longer chains are
+// rewritten to point at the last hop that redirects like the first one.
+//
+// A hop is only followed when its outcome doesn't depend on the request or
+// the time: the first exact rule for the path must be an unconditional
+// redirect without a query requirement or time window, in a host block without
+// conditions or time window that evaluates exact rules first, it must have the
+// block's highest priority, and neither its target nor the block's to_host may
+// contain references.
+func (s *snapshot) resolveChains(flatten bool) error {
+	finals := make(map[*compiledRule]chainEnd)
+	for i := range s.hosts {
+		b := &s.hosts[i]
+		for _, from := range slices.Sorted(maps.Keys(b.exactPaths)) {
+			for _, cr := range b.exactPaths[from] {
+				if !chainable(b, cr) {
+					continue
+				}
+				end, hops, err := s.follow(chainHop{block: b, from: from, rule: cr})
+				if err != nil {
+					return err
+				}
+				if hops > 1 {
+					finals[cr] = end
+				}
+			}
+		}
+	}
+
+	if flatten {
+		for cr, end := range finals {
+			cr.flatten(end)
+		}
+	}
+	return nil
+}
+
+// follow walks the chain starting at start to detect loops. It returns where
+// the hops that redirect like start end, together with their number, as
+// flattening past a hop with another status or query policy would change
+// the response.
+func (s *snapshot) follow(start chainHop) (chainEnd, int, error) {
+	hops := []chainHop{start}
+	var end, flat chainEnd
+	flatHops := 0
+	for {
+		cur := hops[len(hops)-1]
+		end = end.next(cur)
+		if flatHops == len(hops)-1 && cur.rule.redirectsLike(start.rule) {
+			flat, flatHops = end, len(hops)
+		}
+
+		next, ok := s.nextHop(cur.block, end)
+		if !ok {
+			return flat, flatHops, nil
+		}
+		if i := slices.IndexFunc(hops, func(h chainHop) bool { return h.rule == next.rule }); i >= 0 {
+			names := make([]string, 0, len(hops)-i+1)
+			for _, h := range hops[i:] {
+				names = append(names, h.String())
+			}
+			names = append(names, next.String())
+			return chainEnd{}, 0, fmt.Errorf("redirect loop: %s", strings.Join(names, " -> "))
+		}
+		hops = append(hops, next)
+	}
+}
+
+// next returns where h sends a request that arrived at e.
+func (e chainEnd) next(h chainHop) chainEnd {
+	raw := h.rule.to.raw
+	if scheme, rest, ok := strings.Cut(raw, "://"); ok && isAbsoluteURL(raw) {
+		host, target := rest, "/"
+		if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+			host, target = rest[:i], rest[i:]
+		}
+		return chainEnd{scheme: scheme, host: host, target: target}
+	}
+	if toHost := h.block.toHost.raw; toHost != "" {
+		return chainEnd{host: toHost, target: raw}
+	}
+	return chainEnd{scheme: e.scheme, host: e.host, target: raw}
+}
+
+// nextHop returns the exact rule a request for e would hit after leaving
+// block, if that can be told without the request.
+func (s *snapshot) nextHop(block *compiledHostBlock, e chainEnd) (chainHop, bool) {
+	path := e.target
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if p, err := url.PathUnescape(path); err == nil {
+		path = p
+	}
+
+	if e.host != "" {
+		block, _ = s.index.lookup(e.host)
+	}
+	if block == nil || len(block.conds) > 0 || block.window != nil {
+		return chainHop{}, false
+	}
+
+	// Only the first exact rule of the highest priority is sure to be tried
+	// first, and only if exact rules come first in the block's order.
+	if block.order[0] != kindExact {
+		return chainHop{}, false
+	}
+	i := slices.IndexFunc(block.exactPaths[path], func(cr *compiledRule) bool { return cr.priority == block.levels[0] })
+	if i < 0 || !chainable(block, block.exactPaths[path][i]) {
+		return chainHop{}, false
+	}
+	return chainHop{block: block, from: path, rule: block.exactPaths[path][i]}, true
+}
+
+func chainable(block *compiledHostBlock, cr *compiledRule) bool {
+	return cr.action == actionRedirect && len(cr.conds) == 0 && !cr.hasQuery() && cr.split == nil && cr.window == nil &&
+		cr.to.static() && block.toHost.static()
+}
+
+// redirectsLike reports whether cr answers with the same status, promotion
+// and query handling as o.
+func (cr *compiledRule) redirectsLike(o *compiledRule) bool {
+	return cr.status == o.status && cr.promoteAt.Equal(o.promoteAt) && cr.queryPolicy.equal(o.queryPolicy)
+}
+
+// flatten points cr at the end of its chain.
+func (cr *compiledRule) flatten(e chainEnd) {
+	target := e.target
+	if e.host != "" && !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	switch {
+	case e.host == "":
+		cr.to = &template{raw: target}
+	case e.scheme != "":
+		cr.to = &template{raw: e.scheme + "://" + e.host + target}
+	default:
+		cr.to = &template{raw: target}
+		cr.toHost = &template{raw: e.host}
+	}
+}
@@ -0,0 +1,338 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+)
+
+// ruleDefaults are the settings a rule inherits from its host block, or the
+// host block from the global configuration, when it doesn't set them itself.
+type ruleDefaults struct {
+	action    string
+	status    int
+	query     *queryPolicy
+	querySet  bool // a query policy is configured, even if it compiled to nil
+	promoteAt time.Time
+}
+
+// compiler holds what is shared while compiling the host blocks of one
+// Redirector.
+type compiler struct {
+	ctx     caddy.Context
+	baseDir string
+	bodies  map[string][]byte // body files by resolved path, read once
+	now     func() time.Time
+	storage certmagic.Storage // for promotions, Caddy's storage if nil
+	geo     *geoDB
+	starts  *pendingStarts // first provision times to store, for promotions
+}
+
+// compile builds the immutable snapshot served by ServeHTTP from the merged
+// host blocks.
+func compile(ctx caddy.Context, r *Redirector, hosts []HostBlock) (*snapshot, error) {
+	if err := checkRedirectCode(r.DefaultCode); err != nil {
+		return nil, err
+	}
+	query, err := compileQueryPolicy(r.Query)
+	if err != nil {
+		return nil, err
+	}
+	global := ruleDefaults{status: r.DefaultCode, query: query, querySet: r.Query != nil}
+	snap := &snapshot{hosts: make([]compiledHostBlock, len(hosts)), index: newHostIndex(), cascade: r.Cascade, now: r.now}
+	if snap.now == nil {
+		snap.now = time.Now
+	}
+	c := &compiler{ctx: ctx, baseDir: r.baseDir, now: snap.now, storage: r.storage}
+	if r.GeoIP != nil {
+		if c.geo, err = openGeoDB(ctx, r.GeoIP, r.baseDir); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, hb := range hosts {
+		ch := &snap.hosts[i]
+		if err := c.hostBlock(ch, hb, global); err != nil {
+			return nil, fmt.Errorf("host %q: %w", hb.Pattern, err)
+		}
+		snap.index.add(hb.Pattern, ch)
+	}
+
+	if err := snap.resolveChains(r.FlattenChains); err != nil {
+		return nil, err
+	}
+	snap.starts = c.starts
+	return snap, nil
+}
+
+func (c *compiler) hostBlock(ch *compiledHostBlock, hb HostBlock, defaults ruleDefaults) error {
+	ch.pattern = hb.Pattern
+	if hb.Status != 0 {
+		if err := checkRedirectCode(hb.Status); err != nil {
+			return err
+		}
+		defaults.status = hb.Status
+	}
+
+	switch hb.Action {
+	case "":
+	case actionNameRedirect, actionNameRewrite:
+		defaults.action = hb.Action
+	default:
+		return fmt.Errorf("action must be redirect or rewrite, %q given", hb.Action)
+	}
+
+	if hb.Query != nil {
+		query, err := compileQueryPolicy(hb.Query)
+		if err != nil {
+			return err
+		}
+		defaults.query, defaults.querySet = query, true
+	}
+
+	if hb.PromoteAfter != "" {
+		at, err := c.promotion(fmt.Sprintf("host %q", hb.Pattern), hb.PromoteAfter)
+		if err != nil {
+			return err
+		}
+		defaults.promoteAt = at
+	}
+
+	if expr, ok := strings.CutPrefix(strings.TrimSpace(hb.Pattern), "~"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		ch.hostRe = re
+	}
+
+	var err error
+	if ch.conds, err = loadConditions(c.ctx, hb.Match, c.geo); err != nil {
+		return err
+	}
+	if ch.order, err = parseOrder(hb.Order); err != nil {
+		return err
+	}
+
+	if ch.locale, err = compileLocale(hb.Locale); err != nil {
+		return err
+	}
+
+	scope := tmplScope{hostRe: ch.hostRe, locale: ch.locale != nil}
+	if ch.toHost, err = compileTemplate(hb.ToHost, scope); err != nil {
+		return fmt.Errorf("to_host: %w", err)
+	}
+	if ch.window, err = c.window(fmt.Sprintf("host %q", hb.Pattern), hb.Schedule, RuleOptions{}, scope, defaults); err != nil {
+		return err
+	}
+
+	if len(hb.Exact) > 0 || len(hb.ExactRules) > 0 {
+		ch.exactPaths = make(map[string][]*compiledRule, len(hb.Exact)+len(hb.ExactRules))
+		add := func(er ExactRule) error {
+			path, query, err := splitQuery(er.From, er.IgnoreExtraQuery)
+			if err != nil {
+				return fmt.Errorf("exact %q: %w", er.From, err)
+			}
+			cr, err := c.rule(fmt.Sprintf("host %q exact %q", hb.Pattern, er.From), er.To, er.RuleOptions, scope, defaults)
+			if err != nil {
+				return fmt.Errorf("exact %q: %w", er.From, err)
+			}
+			cr.query = query
+			ch.exactPaths[path] = insertByQuery(ch.exactPaths[path], cr)
+			ch.addLevel(cr.priority)
+			return nil
+		}
+		for _, er := range hb.ExactRules {
+			if err := add(er); err != nil {
+				return err
+			}
+		}
+		for _, from := range slices.Sorted(maps.Keys(hb.Exact)) {
+			if err := add(ExactRule{From: from, To: hb.Exact[from]}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(hb.Path) > 0 {
+		ch.paths = &pathTree{}
+		for _, pr := range hb.Path {
+			segs, vars, err := parsePathTemplate(pr.Pattern)
+			if err != nil {
+				return err
+			}
+
+			cr, err := c.rule(fmt.Sprintf("host %q path %q", hb.Pattern, pr.Pattern), pr.To, pr.RuleOptions, tmplScope{hostRe: ch.hostRe, pathVars: vars, locale: scope.locale}, defaults)
+			if err != nil {
+				return fmt.Errorf("path %q: %w", pr.Pattern, err)
+			}
+			ch.paths.insert(segs, &compiledPathRule{compiledRule: *cr, pattern: pr.Pattern, vars: vars})
+			ch.addLevel(cr.priority)
+		}
+	}
+
+	if len(hb.Prefix) > 0 {
+		ch.prefixes = &prefixTree{}
+		for _, pr := range hb.Prefix {
+			from, query, err := splitQuery(pr.From, pr.IgnoreExtraQuery)
+			if err != nil {
+				return fmt.Errorf("prefix %q: %w", pr.From, err)
+			}
+			cr, err := c.rule(fmt.Sprintf("host %q prefix %q", hb.Pattern, pr.From), pr.To, pr.RuleOptions, tmplScope{hostRe: ch.hostRe, prefix: true, locale: scope.locale}, defaults)
+			if err != nil {
+				return fmt.Errorf("prefix %q: %w", pr.From, err)
+			}
+			cr.query = query
+			ch.prefixes.insert(&compiledPrefixRule{compiledRule: *cr, from: from})
+			ch.addLevel(cr.priority)
+		}
+	}
+
+	if len(hb.Regex) > 0 {
+		rules := make([]compiledRegexRule, 0, len(hb.Regex))
+		for _, rr := range hb.Regex {
+			re, err := regexp.Compile(rr.Pattern)
+			if err != nil {
+				return err
+			}
+
+			cr, err := c.rule(fmt.Sprintf("host %q regex %q", hb.Pattern, rr.Pattern), rr.To, rr.RuleOptions, tmplScope{hostRe: ch.hostRe, pathRe: re, locale: scope.locale}, defaults)
+			if err != nil {
+				return fmt.Errorf("regex %q: %w", rr.Pattern, err)
+			}
+			rules = append(rules, compiledRegexRule{compiledRule: *cr, re: re, lits: analyzeRegex(rr.Pattern)})
+			ch.addLevel(cr.priority)
+		}
+		ch.regex = newRegexIndex(rules)
+	}
+
+	return nil
+}
+
+// rule compiles the parts shared by all rule types. key identifies the rule
+// across provisions, for promote_after.
+func (c *compiler) rule(key, to string, opts RuleOptions, scope tmplScope, defaults ruleDefaults) (*compiledRule, error) {
+	tmpl, err := compileTemplate(to, scope)
+	if err != nil {
+		return nil, err
+	}
+	cr := &compiledRule{to: tmpl, status: defaults.status, priority: opts.Priority, queryPolicy: defaults.query}
+
+	action := opts.Action
+	if action == "" {
+		action = defaults.action
+	}
+
+	switch action {
+	case "", actionNameRedirect, actionNameRewrite:
+		if opts.Body != "" || opts.BodyFile != "" || opts.ContentType != "" {
+			return nil, fmt.Errorf("body, body_file and content_type need a response action")
+		}
+		if action == actionNameRewrite {
+			if opts.Status != 0 {
+				return nil, fmt.Errorf("status can't be set on a rewrite")
+			}
+			cr.action = actionRewrite
+		} else if opts.Status != 0 {
+			if err := checkRedirectCode(opts.Status); err != nil {
+				return nil, err
+			}
+			cr.status = opts.Status
+		}
+	default:
+		status, ok := responseActions[action]
+		if !ok {
+			return nil, fmt.Errorf("unknown action %q", action)
+		}
+		if opts.Status != 0 {
+			return nil, fmt.Errorf("action %s answers with %d, status can't be set", action, status)
+		}
+		response, err := c.response(status, opts)
+		if err != nil {
+			return nil, err
+		}
+		cr.action, cr.status, cr.response = actionRespond, status, response
+	}
+
+	switch {
+	case len(opts.Targets) > 0:
+		if to != "" {
+			return nil, fmt.Errorf("to and targets can't be combined")
+		}
+		if cr.action == actionRespond {
+			return nil, fmt.Errorf("targets need a redirect or rewrite action")
+		}
+		if cr.split, err = compileSplit(opts.Targets, opts.Sticky, scope); err != nil {
+			return nil, err
+		}
+		cr.to = cr.split.variants[0].to
+		if cr.action == actionRedirect {
+			if opts.Status != 0 {
+				if err := checkSplitStatus(opts.Status); err != nil {
+					return nil, err
+				}
+			} else if tmp, ok := temporaryCodes[cr.status]; ok {
+				cr.status = tmp
+			}
+		}
+	case opts.Sticky != "":
+		return nil, fmt.Errorf("sticky needs targets")
+	}
+
+	if cr.conds, err = loadConditions(c.ctx, opts.Match, c.geo); err != nil {
+		return nil, err
+	}
+	if cr.window, err = c.window(key, opts.Schedule, opts, scope, defaults); err != nil {
+		return nil, err
+	}
+
+	if opts.PromoteAfter != "" {
+		if cr.split != nil {
+			return nil, fmt.Errorf("promote_after can't be combined with targets")
+		}
+		at, err := c.promotion(key, opts.PromoteAfter)
+		if err != nil {
+			return nil, err
+		}
+		if err := cr.promote(at, true); err != nil {
+			return nil, err
+		}
+	} else if !defaults.promoteAt.IsZero() && cr.split == nil {
+		_ = cr.promote(defaults.promoteAt, false)
+	}
+
+	if opts.Query != nil {
+		if cr.queryPolicy, err = compileQueryPolicy(opts.Query); err != nil {
+			return nil, err
+		}
+	} else if cr.action == actionRewrite && !defaults.querySet {
+		cr.queryPolicy = rewriteQueryPolicy
+	}
+	return cr, nil
+}
+
+// redirectCodes are the status codes a redirect may use.
+var redirectCodes = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+func checkRedirectCode(code int) error {
+	if !slices.Contains(redirectCodes, code) {
+		return fmt.Errorf("status must be 301, 302, 303, 307 or 308, %d given", code)
+	}
+	return nil
+}
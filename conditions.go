@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// cookieMatcherName is handled by the module itself, since Caddy ships no
+// cookie request matcher.
+const cookieMatcherName = "cookie"
+
+// loadConditions turns a Conditions set into Caddy request matchers. Every
+// entry except cookie and geo is loaded as the http.matchers module of the
+// same name, so the syntax is exactly the one of Caddy's JSON config. geo
+// conditions look up the client in db.
+func loadConditions(ctx caddy.Context, conds Conditions, db *geoDB) (caddyhttp.MatcherSet, error) {
+	if len(conds) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(conds))
+	for name := range conds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	set := make(caddyhttp.MatcherSet, 0, len(names))
+	for _, name := range names {
+		raw, err := json.Marshal(conds[name])
+		if err != nil {
+			return nil, fmt.Errorf("match %q: %w", name, err)
+		}
+
+		if name == cookieMatcherName {
+			var cm cookieMatcher
+			if err := json.Unmarshal(raw, &cm); err != nil {
+				return nil, fmt.Errorf("match %q: %w", name, err)
+			}
+			set = append(set, cm)
+			continue
+		}
+		if name == geoMatcherName {
+			gm := &geoMatcher{}
+			if err := json.Unmarshal(raw, gm); err != nil {
+				return nil, fmt.Errorf("match %q: %w", name, err)
+			}
+			if err := gm.provision(db); err != nil {
+				return nil, fmt.Errorf("match %q: %w", name, err)
+			}
+			set = append(set, gm)
+			continue
+		}
+
+		mod, err := ctx.LoadModuleByID("http.matchers."+name, raw)
+		if err != nil {
+			return nil, fmt.Errorf("match %q: %w", name, err)
+		}
+		set = append(set, mod)
+	}
+	return set, nil
+}
+
+// applies reports whether req satisfies all conditions. An empty set always
+// applies.
+func applies(conds caddyhttp.MatcherSet, req *http.Request) (bool, error) {
+	if len(conds) == 0 {
+		return true, nil
+	}
+	return conds.MatchWithError(req)
+}
+
+// cookieMatcher matches requests by cookie. Each listed cookie must be
+// present; if values are given, the cookie must have one of them.
+type cookieMatcher map[string][]string
+
+func (cm cookieMatcher) MatchWithError(req *http.Request) (bool, error) {
+	for name, values := range cm {
+		c, err := req.Cookie(name)
+		if err != nil {
+			return false, nil
+		}
+		if len(values) > 0 && !slices.Contains(values, c.Value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// UnmarshalCaddyfile parses one or more lines of the form
+//
+//	cookie <name> [<values...>]
+func (cm cookieMatcher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		var name string
+		if !d.Args(&name) {
+			return d.ArgErr()
+		}
+		cm[name] = append(cm[name], d.RemainingArgs()...)
+	}
+	return nil
+}
+
+var (
+	_ caddyhttp.RequestMatcherWithError = cookieMatcher(nil)
+	_ caddyfile.Unmarshaler             = cookieMatcher(nil)
+)
@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"time"
+
+	"github.com/caddyserver/certmagic"
+)
+
+// SetClock makes r check time windows and promotions against now.
+func SetClock(r *Redirector, now func() time.Time) { r.now = now }
+
+// SetStorage makes r keep the first provision times of promotions in s.
+func SetStorage(r *Redirector, s certmagic.Storage) { r.storage = s }
@@ -4,11 +4,14 @@
 package redirector_test
 
 import (
+	"context"
 	"path/filepath"
+	"time"
 
 	redir "github.com/Bl4cky99/caddy-redirector"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -30,7 +33,7 @@ func (s *Suite) BuildRedirectorFromFiles(defaultCode int, paths ...string) *redi
 		r.RulesFiles = append(r.RulesFiles, redir.RulesFile{Path: ConfigPath(rel)})
 	}
 
-	Expect(r.Provision(caddy.Context{})).To(Succeed())
+	Expect(r.Provision(s.Context())).To(Succeed())
 	return r
 }
 
@@ -41,7 +44,29 @@ func (s *Suite) BuildRedirectorInline(defaultCode int, hosts []redir.HostBlock)
 		DefaultCode: defaultCode,
 		Hosts:       hosts,
 	}
-	Expect(r.Provision(caddy.Context{})).To(Succeed())
+	Expect(r.Provision(s.Context())).To(Succeed())
+	return r
+}
+
+// Context returns a caddy.Context able to load modules, as needed by rules with
+// match conditions. It is cancelled when the current spec ends.
+func (s *Suite) Context() caddy.Context {
+	GinkgoHelper()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	DeferCleanup(cancel)
+	return ctx
+}
+
+// WithClock makes r check time windows and promotions against now.
+func WithClock(r *redir.Redirector, now func() time.Time) *redir.Redirector {
+	redir.SetClock(r, now)
+	return r
+}
+
+// WithStorage makes r keep the first provision times of promotions in st.
+func WithStorage(r *redir.Redirector, st certmagic.Storage) *redir.Redirector {
+	redir.SetStorage(r, st)
 	return r
 }
 
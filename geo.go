@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+)
+
+// geoMatcherName is the built-in condition on the country or continent of
+// the client, looked up in the GeoIP database of the Redirector.
+const geoMatcherName = "geo"
+
+// defaultGeoCheckInterval is how often the database file is checked for
+// changes.
+const defaultGeoCheckInterval = time.Minute
+
+// geoRecord is the part of a GeoIP2/GeoLite2 Country or City record the
+// geo condition uses.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// geoDB is a MaxMind DB file read into memory. It is reloaded in the
+// background when the file's size or modification time changes, so a
+// database updater can replace it while Caddy runs.
+type geoDB struct {
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+	stat   os.FileInfo // of the loaded file, only used by load
+}
+
+func openGeoDB(ctx caddy.Context, cfg *GeoIPConfig, baseDir string) (*geoDB, error) {
+	if cfg.Database == "" {
+		return nil, fmt.Errorf("geoip: database path is empty")
+	}
+	db := &geoDB{path: resolvePath(baseDir, cfg.Database)}
+	if _, err := db.load(); err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+
+	interval := time.Duration(cfg.CheckInterval)
+	if interval <= 0 {
+		interval = defaultGeoCheckInterval
+	}
+	go db.watch(ctx, interval)
+	return db, nil
+}
+
+// load reads the file if it changed since the last load and reports whether
+// it did.
+func (db *geoDB) load() (bool, error) {
+	fi, err := os.Stat(db.path)
+	if err != nil {
+		return false, err
+	}
+	if db.stat != nil && fi.Size() == db.stat.Size() && fi.ModTime().Equal(db.stat.ModTime()) {
+		return false, nil
+	}
+
+	// The file is read instead of mapped, so replacing or truncating it
+	// can't affect lookups in flight.
+	b, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, err
+	}
+	r, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", db.path, err)
+	}
+	db.reader.Store(r)
+	db.stat = fi
+	return true, nil
+}
+
+// watch reloads the database when the file changes until ctx is done. A file
+// that fails to load is logged and the previous database stays in use.
+func (db *geoDB) watch(ctx caddy.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reloaded, err := db.load()
+			switch {
+			case err != nil:
+				ctx.Logger().Error("reloading geoip database", zap.String("path", db.path), zap.Error(err))
+			case reloaded:
+				ctx.Logger().Info("reloaded geoip database", zap.String("path", db.path))
+			}
+		}
+	}
+}
+
+func (db *geoDB) lookup(ip net.IP) (geoRecord, bool) {
+	var rec geoRecord
+	_, ok, err := db.reader.Load().LookupNetwork(ip, &rec)
+	return rec, ok && err == nil
+}
+
+// geoMatcher matches requests whose client IP is located in one of the
+// listed countries (ISO 3166-1 alpha-2) and, if given, continents (AF, AN,
+// AS, EU, NA, OC, SA). The client IP is the one Caddy determined, which
+// honours trusted proxies.
+type geoMatcher struct {
+	Country   []string `json:"country,omitempty"`
+	Continent []string `json:"continent,omitempty"`
+
+	db *geoDB
+}
+
+func (gm *geoMatcher) provision(db *geoDB) error {
+	if db == nil {
+		return fmt.Errorf("needs a geoip database")
+	}
+	if len(gm.Country) == 0 && len(gm.Continent) == 0 {
+		return fmt.Errorf("needs a country or continent")
+	}
+	for i := range gm.Country {
+		gm.Country[i] = strings.ToUpper(gm.Country[i])
+	}
+	for i := range gm.Continent {
+		gm.Continent[i] = strings.ToUpper(gm.Continent[i])
+	}
+	gm.db = db
+	return nil
+}
+
+func (gm *geoMatcher) MatchWithError(req *http.Request) (bool, error) {
+	ip := net.ParseIP(clientIP(req))
+	if ip == nil {
+		return false, nil
+	}
+	rec, ok := gm.db.lookup(ip)
+	if !ok {
+		return false, nil
+	}
+	if len(gm.Country) > 0 && !slices.Contains(gm.Country, rec.Country.ISOCode) {
+		return false, nil
+	}
+	if len(gm.Continent) > 0 && !slices.Contains(gm.Continent, rec.Continent.Code) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// UnmarshalCaddyfile parses one or more lines of the form
+//
+//	geo country|continent <codes...>
+func (gm *geoMatcher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		var field string
+		if !d.Args(&field) {
+			return d.ArgErr()
+		}
+		codes := d.RemainingArgs()
+		if len(codes) == 0 {
+			return d.ArgErr()
+		}
+		switch field {
+		case "country":
+			gm.Country = append(gm.Country, codes...)
+		case "continent":
+			gm.Continent = append(gm.Continent, codes...)
+		default:
+			return d.Errf("geo matches country or continent, %q given", field)
+		}
+	}
+	return nil
+}
+
+var (
+	_ caddyhttp.RequestMatcherWithError = (*geoMatcher)(nil)
+	_ caddyfile.Unmarshaler             = (*geoMatcher)(nil)
+)
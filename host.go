@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// hostIndex resolves a request host to its host block without scanning all
+// blocks: exact hosts live in a map, wildcard suffixes in a trie keyed by
+// labels from right to left, so the deepest (most specific) wildcard wins.
+// Patterns may carry a port, in which case they only match that port and take
+// precedence over the port-less pattern for the same host. Regex patterns
+// (~...) are tried in declaration order after wildcards.
+type hostIndex struct {
+	exact    map[string]*portBlocks
+	wildcard labelNode
+	regex    []*compiledHostBlock
+	matchAll portBlocks
+}
+
+type labelNode struct {
+	children map[string]*labelNode
+	blocks   portBlocks
+}
+
+type portBlocks struct {
+	any   *compiledHostBlock
+	ports map[string]*compiledHostBlock
+}
+
+func newHostIndex() *hostIndex {
+	return &hostIndex{exact: make(map[string]*portBlocks)}
+}
+
+// add registers block under pattern. If a pattern is declared twice, the first
+// block keeps it.
+func (x *hostIndex) add(pattern string, block *compiledHostBlock) {
+	pattern = strings.TrimSpace(pattern)
+	switch {
+	case block.hostRe != nil:
+		x.regex = append(x.regex, block)
+	case pattern == "*" || strings.HasPrefix(pattern, "*:"):
+		x.matchAll.add(strings.TrimPrefix(pattern[1:], ":"), block)
+	case strings.HasPrefix(pattern, "*.") && len(pattern) > 2:
+		host, port := normalizeHost(pattern[2:])
+		n := &x.wildcard
+		for host != "" {
+			var label string
+			label, host = lastLabel(host)
+			c := n.children[label]
+			if c == nil {
+				if n.children == nil {
+					n.children = make(map[string]*labelNode)
+				}
+				c = &labelNode{}
+				n.children[label] = c
+			}
+			n = c
+		}
+		n.blocks.add(port, block)
+	default:
+		host, port := normalizeHost(pattern)
+		pb := x.exact[host]
+		if pb == nil {
+			pb = &portBlocks{}
+			x.exact[host] = pb
+		}
+		pb.add(port, block)
+	}
+}
+
+// lookup returns the block for a request host: an exact match, else the most
+// specific wildcard, else the first matching regex, else the catch-all. A
+// wildcard never matches its own apex. For regex blocks the submatches of the
+// host are returned as well.
+func (x *hostIndex) lookup(reqHost string) (block *compiledHostBlock, groups []string) {
+	x.each(reqHost, func(b *compiledHostBlock, g []string) bool {
+		block, groups = b, g
+		return true
+	})
+	return block, groups
+}
+
+// each calls fn for every block matching a request host, in the order lookup
+// prefers them, until fn returns true: the exact host, the wildcards from the
+// most to the least specific, the matching regex patterns in declaration order
+// and the catch-all. Where a pattern exists with and without the port, the
+// one naming the port comes first.
+func (x *hostIndex) each(reqHost string, fn func(*compiledHostBlock, []string) bool) {
+	host, port := normalizeHost(reqHost)
+	if pb := x.exact[host]; pb != nil && pb.each(port, fn) {
+		return
+	}
+
+	var buf [8]*labelNode
+	wildcards := buf[:0]
+	n := &x.wildcard
+	rest := host
+	for rest != "" {
+		var label string
+		label, rest = lastLabel(rest)
+		n = n.children[label]
+		if n == nil {
+			break
+		}
+		if rest != "" {
+			wildcards = append(wildcards, n)
+		}
+	}
+	for i := len(wildcards) - 1; i >= 0; i-- {
+		if wildcards[i].blocks.each(port, fn) {
+			return
+		}
+	}
+
+	for _, b := range x.regex {
+		if groups := b.hostRe.FindStringSubmatch(host); groups != nil && fn(b, groups) {
+			return
+		}
+	}
+
+	x.matchAll.each(port, fn)
+}
+
+func (p *portBlocks) add(port string, block *compiledHostBlock) {
+	if port == "" {
+		if p.any == nil {
+			p.any = block
+		}
+		return
+	}
+	if p.ports == nil {
+		p.ports = make(map[string]*compiledHostBlock)
+	}
+	if _, ok := p.ports[port]; !ok {
+		p.ports[port] = block
+	}
+}
+
+func (p *portBlocks) each(port string, fn func(*compiledHostBlock, []string) bool) bool {
+	if port != "" {
+		if b, ok := p.ports[port]; ok && fn(b, nil) {
+			return true
+		}
+	}
+	return p.any != nil && fn(p.any, nil)
+}
+
+// lastLabel splits the right-most DNS label off host.
+func lastLabel(host string) (label, rest string) {
+	i := strings.LastIndexByte(host, '.')
+	if i < 0 {
+		return host, ""
+	}
+	return host[i+1:], host[:i]
+}
+
+// normalizeHost turns a Host header or host pattern into its lookup form: the
+// port is split off, brackets around IPv6 literals and the trailing root dot
+// are dropped, IP addresses are canonicalized and internationalized names are
+// converted to lowercase punycode.
+func normalizeHost(s string) (host, port string) {
+	host = s
+	if strings.HasPrefix(host, "[") {
+		if end := strings.IndexByte(host, ']'); end > 0 {
+			port = strings.TrimPrefix(host[end+1:], ":")
+			host = host[1:end]
+		}
+	} else if i := strings.LastIndexByte(host, ':'); i >= 0 && strings.IndexByte(host[:i], ':') < 0 {
+		host, port = host[:i], host[i+1:]
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return "", port
+	}
+
+	if c := host[0]; (c >= '0' && c <= '9') || strings.IndexByte(host, ':') >= 0 {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			return ip.String(), port
+		}
+	}
+
+	return toASCIIHost(host), port
+}
+
+func toASCIIHost(host string) string {
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			if a, err := idna.Lookup.ToASCII(host); err == nil {
+				return a
+			}
+			break
+		}
+	}
+	return strings.ToLower(host)
+}
+
+// hostKey is the canonical form of a host pattern, used to merge blocks from
+// different rule files that name the same host in different spellings.
+func hostKey(pattern string) string {
+	pattern = strings.TrimSpace(pattern)
+	prefix := ""
+	switch {
+	case pattern == "*" || strings.HasPrefix(pattern, "*:") || strings.HasPrefix(pattern, "~"):
+		return pattern
+	case strings.HasPrefix(pattern, "*."):
+		prefix, pattern = "*.", pattern[2:]
+	}
+
+	host, port := normalizeHost(pattern)
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return prefix + host
+}
@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// linter collects warnings about rules and host blocks that can never apply.
+// They are logged at provision time, or fail it in strict mode.
+type linter struct {
+	warnings []string
+}
+
+func (l *linter) warnf(format string, args ...any) {
+	if l != nil {
+		l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+// report logs the warnings, or returns them as errors in strict mode.
+func (l *linter) report(ctx caddy.Context, strict bool) error {
+	if len(l.warnings) == 0 {
+		return nil
+	}
+	if strict {
+		errs := make([]error, len(l.warnings))
+		for i, w := range l.warnings {
+			errs[i] = errors.New(w)
+		}
+		return fmt.Errorf("strict: %w", errors.Join(errs...))
+	}
+
+	log := ctx.Logger()
+	for _, w := range l.warnings {
+		log.Warn("unreachable rule", zap.String("problem", w))
+	}
+	return nil
+}
+
+// overrides warns about exact rules of a rule file that replace a different
+// target merged in from an earlier source, since only the last one is kept.
+func (l *linter) overrides(dst, src []HostBlock, file string) {
+	if l == nil {
+		return
+	}
+	for _, s := range src {
+		i := slices.IndexFunc(dst, func(hb HostBlock) bool { return hostKey(hb.Pattern) == hostKey(s.Pattern) })
+		if i < 0 {
+			continue
+		}
+		for _, from := range slices.Sorted(maps.Keys(s.Exact)) {
+			if prev, ok := dst[i].Exact[from]; ok && prev != s.Exact[from] {
+				l.warnf("rule_file %q: host %q: exact %q replaces target %q of an earlier source with %q", file, s.Pattern, from, prev, s.Exact[from])
+			}
+		}
+	}
+}
+
+// snapshot looks for host blocks hidden by an earlier one and for rules that
+// an earlier rule of their host block always takes precedence over.
+func (l *linter) snapshot(s *snapshot) {
+	seen := make(map[string]string, len(s.hosts))
+	for i := range s.hosts {
+		b := &s.hosts[i]
+		key := hostKey(b.pattern)
+		if first, ok := seen[key]; ok {
+			l.warnf("host %q is hidden by host %q declared before it", b.pattern, first)
+			continue
+		}
+		seen[key] = b.pattern
+		l.block(b)
+	}
+}
+
+func (l *linter) block(b *compiledHostBlock) {
+	for _, path := range slices.Sorted(maps.Keys(b.exactPaths)) {
+		l.shadowed(b, "exact", path, b.exactPaths[path])
+	}
+
+	if b.prefixes != nil {
+		b.prefixes.each(func(rules []*compiledPrefixRule) {
+			crs := make([]*compiledRule, len(rules))
+			for i, pr := range rules {
+				crs[i] = &pr.compiledRule
+			}
+			l.shadowed(b, "prefix", rules[0].from, crs)
+		})
+	}
+
+	if b.regex == nil {
+		return
+	}
+	rules := b.regex.rules
+	for i := range rules {
+		rr := &rules[i]
+		duplicate := false
+		for j := range rules {
+			e := &rules[j]
+			if e.re.String() == rr.re.String() && unconditional(&e.compiledRule) && triedBefore(e.priority, j, rr.priority, i) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			l.warnf("host %q: regex %q is never used, another regex with the same pattern always applies first", b.pattern, rr.re.String())
+			continue
+		}
+		if b.prefixes == nil {
+			continue
+		}
+
+		// Every path the regex matches starts with its literal prefix, and
+		// every request path starts with /.
+		lit := rr.lits.prefix
+		if lit == "" {
+			lit = "/"
+		}
+		var hit *compiledPrefixRule
+		b.prefixes.walk(lit, func(rules []*compiledPrefixRule) {
+			for _, pr := range rules {
+				if hit == nil && always(&pr.compiledRule) && b.before(kindPrefix, pr.priority, kindRegex, rr.priority) {
+					hit = pr
+				}
+			}
+		})
+		if hit != nil {
+			l.warnf("host %q: regex %q is never used, prefix %q matches every path it does", b.pattern, rr.re.String(), hit.from)
+		}
+	}
+}
+
+// shadowed warns about the rules of a candidate list that another
+// unconditional rule with the same query requirement always wins over.
+func (l *linter) shadowed(b *compiledHostBlock, kind, from string, rules []*compiledRule) {
+	for i, r := range rules {
+		for j, e := range rules {
+			if unconditional(e) && (e.query == nil || e.query.equal(r.query)) && triedBefore(e.priority, j, r.priority, i) {
+				l.warnf("host %q: %s %q is never used, another rule with the same from always applies first", b.pattern, kind, describeFrom(from, r.query))
+				break
+			}
+		}
+	}
+}
+
+// triedBefore reports whether the candidate at position j with priority pj is
+// tried before the one at position i with priority pi: by priority, then in
+// declaration order.
+func triedBefore(pj, j, pi, i int) bool {
+	return pj > pi || pj == pi && j < i
+}
+
+// always reports whether a rule accepts every request that reaches it.
+func always(cr *compiledRule) bool {
+	return unconditional(cr) && cr.query == nil
+}
+
+// unconditional reports whether a rule applies regardless of the request's
+// conditions, the time and a locale in its path.
+func unconditional(cr *compiledRule) bool {
+	return len(cr.conds) == 0 && cr.window == nil && !cr.to.addsLocale
+}
+
+func describeFrom(path string, q *queryMatch) string {
+	if q == nil {
+		return path
+	}
+	return path + "?" + q.params.Encode()
+}
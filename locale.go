@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// localeRef is the template reference a host block with a locale config
+// resolves to the request's locale.
+const localeRef = "locale"
+
+// localeConfig is the compiled form of a LocaleConfig.
+type localeConfig struct {
+	locales []string          // as configured
+	byTag   map[string]string // lower-cased tag -> configured locale
+	def     string
+	cookie  string
+	vary    []string // Vary header of responses that depend on the negotiation
+}
+
+func compileLocale(lc *LocaleConfig) (*localeConfig, error) {
+	if lc == nil {
+		return nil, nil
+	}
+	if err := checkLocale(lc); err != nil {
+		return nil, err
+	}
+	c := &localeConfig{locales: lc.Locales, byTag: make(map[string]string, len(lc.Locales)), def: lc.Locales[0], cookie: lc.Cookie}
+	for _, l := range lc.Locales {
+		c.byTag[strings.ToLower(l)] = l
+	}
+	if lc.Default != "" {
+		c.def = c.byTag[strings.ToLower(lc.Default)]
+	}
+	c.vary = []string{"Accept-Language"}
+	if c.cookie != "" {
+		c.vary = []string{"Cookie", "Accept-Language"}
+	}
+	return c, nil
+}
+
+// checkLocale is shared by the validator and the compiler.
+func checkLocale(lc *LocaleConfig) error {
+	if len(lc.Locales) == 0 {
+		return fmt.Errorf("locale needs at least one locale")
+	}
+	for _, l := range lc.Locales {
+		if l == "" || strings.ContainsAny(l, "/?#*;, ") {
+			return fmt.Errorf("locale %q is not a language tag", l)
+		}
+	}
+	if lc.Default != "" && !slices.ContainsFunc(lc.Locales, func(l string) bool { return strings.EqualFold(l, lc.Default) }) {
+		return fmt.Errorf("default locale %q is not one of %s", lc.Default, strings.Join(lc.Locales, ", "))
+	}
+	return nil
+}
+
+// pathLocale returns the configured locale the path starts with, as in
+// /de/pricing or /de.
+func (c *localeConfig) pathLocale(path string) string {
+	seg := strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(seg, '/'); i >= 0 {
+		seg = seg[:i]
+	}
+	return c.byTag[strings.ToLower(seg)]
+}
+
+// negotiate picks a locale from the Accept-Language header: by quality, then
+// by position, taking the first configured locale that equals a language
+// range or shares its primary language. A wildcard or no match gives the
+// default.
+func (c *localeConfig) negotiate(header string) string {
+	type langRange struct {
+		tag string
+		q   float64
+	}
+	var ranges []langRange
+	for entry := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for p := range strings.SplitSeq(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, langRange{tag: tag, q: q})
+		}
+	}
+	slices.SortStableFunc(ranges, func(a, b langRange) int { return cmp.Compare(b.q, a.q) })
+
+	for _, r := range ranges {
+		if r.tag == "*" {
+			return c.def
+		}
+		if l, ok := c.byTag[r.tag]; ok {
+			return l
+		}
+		primary, _, _ := strings.Cut(r.tag, "-")
+		for _, l := range c.locales {
+			lp, _, _ := strings.Cut(strings.ToLower(l), "-")
+			if lp == primary {
+				return l
+			}
+		}
+	}
+	return c.def
+}
+
+// locale returns the request's locale: the one its path starts with, else a
+// configured locale named by the override cookie, else the negotiated one.
+// Unless it came from the path, responses vary on what was consulted.
+func (m *match) locale() string {
+	if m.loc != "" || m.locales == nil {
+		return m.loc
+	}
+	lc := m.locales
+	if m.loc = lc.pathLocale(m.path); m.loc != "" {
+		return m.loc
+	}
+	m.addVary(lc.vary...)
+	if lc.cookie != "" {
+		if ck, err := m.req.Cookie(lc.cookie); err == nil {
+			if l, ok := lc.byTag[strings.ToLower(ck.Value)]; ok {
+				m.loc = l
+				return l
+			}
+		}
+	}
+	m.loc = lc.negotiate(strings.Join(m.req.Header.Values("Accept-Language"), ","))
+	return m.loc
+}
+
+// localized reports whether a rule would add a locale to a path that already
+// starts with one, as /{locale}/pricing would for /de/pricing.
+func (m *match) localized(cr *compiledRule) bool {
+	return cr.to.addsLocale && m.locales != nil && m.locales.pathLocale(m.path) != ""
+}
@@ -3,14 +3,46 @@
 
 package redirector
 
-import "regexp"
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+)
 
 type Redirector struct {
-	Hosts       []HostBlock
-	DefaultCode int
-	RulesFiles  []RulesFile
+	Hosts         []HostBlock
+	DefaultCode   int
+	Query         *QueryPolicy
+	RulesFiles    []RulesFile
+	FlattenChains bool
+	Strict        bool
+	Cascade       bool
+	GeoIP         *GeoIPConfig
+
+	// now is the clock time windows and promotions are checked against,
+	// time.Now if nil.
+	now func() time.Time
+	// storage keeps the first provision time of promotions, Caddy's
+	// configured storage if nil.
+	storage certmagic.Storage
 
 	baseDir string `json:"-"`
+	state   atomic.Pointer[snapshot]
+}
+
+// GeoIPConfig points to a MaxMind DB file, such as GeoLite2 Country or City,
+// used by geo conditions. The file is checked for changes every
+// CheckInterval (default 1m) and reloaded without a Caddy reload.
+type GeoIPConfig struct {
+	Database      string         `json:"database"`
+	CheckInterval caddy.Duration `json:"check_interval,omitempty"`
 }
 
 type RulesFile struct {
@@ -23,36 +55,272 @@ type ExternalRules struct {
 }
 
 type HostBlock struct {
-	Pattern string            `json:"pattern" yaml:"pattern" toml:"pattern"`
-	ToHost  string            `json:"to_host" yaml:"to_host" toml:"to_host"`
-	Status  int               `json:"status" yaml:"status" toml:"status"`
-	Exact   map[string]string `json:"exact" yaml:"exact" toml:"exact"`
-	Prefix  []PrefixRule      `json:"prefix" yaml:"prefix" toml:"prefix"`
-	Regex   []RegexRule       `json:"regex" yaml:"regex" toml:"regex"`
+	Pattern    string            `json:"pattern" yaml:"pattern" toml:"pattern"`
+	ToHost     string            `json:"to_host" yaml:"to_host" toml:"to_host"`
+	Status     int               `json:"status" yaml:"status" toml:"status"`
+	Match      Conditions        `json:"match,omitempty" yaml:"match,omitempty" toml:"match,omitempty"`
+	Query      *QueryPolicy      `json:"query,omitempty" yaml:"query,omitempty" toml:"query,omitempty"`
+	Action     string            `json:"action,omitempty" yaml:"action,omitempty" toml:"action,omitempty"`
+	Order      []string          `json:"order,omitempty" yaml:"order,omitempty" toml:"order,omitempty"`
+	Exact      map[string]string `json:"exact" yaml:"exact" toml:"exact"`
+	ExactRules []ExactRule       `json:"exact_rules,omitempty" yaml:"exact_rules,omitempty" toml:"exact_rules,omitempty"`
+	Prefix     []PrefixRule      `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Regex      []RegexRule       `json:"regex" yaml:"regex" toml:"regex"`
+	Path       []PathRule        `json:"path" yaml:"path" toml:"path"`
+	Locale     *LocaleConfig     `json:"locale,omitempty" yaml:"locale,omitempty" toml:"locale,omitempty"`
+	Schedule   `yaml:",inline"`
+
+	// PromoteAfter switches the block's 302 and 307 redirects to 301 and 308,
+	// see RuleOptions.PromoteAfter.
+	PromoteAfter Timestamp `json:"promote_after,omitempty" yaml:"promote_after,omitempty" toml:"promote_after,omitempty"`
+}
+
+// RuleOptions are the settings every rule type accepts in addition to its
+// from/pattern and to fields.
+type RuleOptions struct {
+	// Status overrides the status code of the host block and the global one.
+	Status int `json:"status,omitempty" yaml:"status,omitempty" toml:"status,omitempty"`
+
+	// Action is redirect (default), rewrite, or a terminal response: gone
+	// (410), not_found (404) or unavailable_for_legal_reasons (451). A rewrite
+	// changes the request URI to the target and passes it on to the next
+	// handler. Responses don't need a To and may carry a Body or BodyFile.
+	Action      string `json:"action,omitempty" yaml:"action,omitempty" toml:"action,omitempty"`
+	Body        string `json:"body,omitempty" yaml:"body,omitempty" toml:"body,omitempty"`
+	BodyFile    string `json:"body_file,omitempty" yaml:"body_file,omitempty" toml:"body_file,omitempty"`
+	ContentType string `json:"content_type,omitempty" yaml:"content_type,omitempty" toml:"content_type,omitempty"`
+
+	Match Conditions `json:"match,omitempty" yaml:"match,omitempty" toml:"match,omitempty"`
+
+	// IgnoreExtraQuery lets an exact or prefix rule whose From has a query
+	// match requests carrying additional parameters.
+	IgnoreExtraQuery bool `json:"ignore_extra_query,omitempty" yaml:"ignore_extra_query,omitempty" toml:"ignore_extra_query,omitempty"`
+
+	Query *QueryPolicy `json:"query,omitempty" yaml:"query,omitempty" toml:"query,omitempty"`
+
+	// Targets splits the requests of a rule between several weighted targets
+	// and replaces To. Sticky keeps a client on its target: cookie <name>,
+	// header <name> or client_ip.
+	Targets []WeightedTarget `json:"targets,omitempty" yaml:"targets,omitempty" toml:"targets,omitempty"`
+	Sticky  string           `json:"sticky,omitempty" yaml:"sticky,omitempty" toml:"sticky,omitempty"`
+
+	// Priority puts a rule ahead of all rules of its host block with a lower
+	// priority, whatever their type. Rules default to 0.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty" toml:"priority,omitempty"`
+
+	Schedule `yaml:",inline"`
+
+	// PromoteAfter switches a 302 or 307 redirect to 301 or 308 once a
+	// duration since the rule was first provisioned has passed, such as 14d,
+	// or at a Timestamp. The first provision time is kept in Caddy's storage.
+	PromoteAfter Timestamp `json:"promote_after,omitempty" yaml:"promote_after,omitempty" toml:"promote_after,omitempty"`
+}
+
+// Schedule limits a rule or host block to a time window. ActiveFrom and
+// ActiveUntil are RFC 3339 timestamps or dates (UTC), either may be omitted.
+// Expired decides what happens after ActiveUntil: pass (default) skips the
+// rule, a response action such as gone answers with it, anything else is a
+// target.
+type Schedule struct {
+	ActiveFrom  Timestamp `json:"active_from,omitempty" yaml:"active_from,omitempty" toml:"active_from,omitempty"`
+	ActiveUntil Timestamp `json:"active_until,omitempty" yaml:"active_until,omitempty" toml:"active_until,omitempty"`
+	Expired     string    `json:"expired,omitempty" yaml:"expired,omitempty" toml:"expired,omitempty"`
+}
+
+// empty reports whether no option is set, so an exact rule fits into the
+// short Exact map form.
+func (o *RuleOptions) empty() bool {
+	return o.Status == 0 && o.Action == "" && o.Body == "" && o.BodyFile == "" && o.ContentType == "" &&
+		len(o.Match) == 0 && !o.IgnoreExtraQuery && o.Query == nil && o.Priority == 0 &&
+		len(o.Targets) == 0 && o.Sticky == "" && o.Schedule == (Schedule{}) &&
+		o.PromoteAfter == ""
+}
+
+// WeightedTarget is one of the targets of a split rule. Name identifies it in
+// the sticky cookie and the {http.redirector.variant} placeholder and defaults
+// to To.
+type WeightedTarget struct {
+	To     string `json:"to" yaml:"to" toml:"to"`
+	Weight int    `json:"weight" yaml:"weight" toml:"weight"`
+	Name   string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+}
+
+// LocaleConfig makes the locale of a request available to the targets of a
+// host block as {locale}. It is taken from a locale at the start of the path,
+// else from Cookie if it names one of Locales, else negotiated from the
+// Accept-Language header, falling back to Default (the first of Locales if
+// empty).
+type LocaleConfig struct {
+	Locales []string `json:"locales" yaml:"locales" toml:"locales"`
+	Default string   `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+	Cookie  string   `json:"cookie,omitempty" yaml:"cookie,omitempty" toml:"cookie,omitempty"`
+}
+
+// Timestamp is a point in time, or for PromoteAfter a duration, as written in
+// the configuration. It takes the text of native TOML and YAML dates as well
+// as strings.
+type Timestamp string
+
+func (t *Timestamp) UnmarshalText(b []byte) error {
+	*t = Timestamp(b)
+	return nil
+}
+
+// QueryPolicy decides what happens to the query string of the request when a
+// target is built. The policy of a rule replaces the one of its host block,
+// which replaces the global one.
+type QueryPolicy struct {
+	// Mode is drop (default), preserve or merge. drop keeps only the query of
+	// the target, preserve appends the request query to it, merge adds only
+	// request parameters the target doesn't set.
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty" toml:"mode,omitempty"`
+	// Remove lists request parameters that are never passed on. A trailing *
+	// matches by prefix, as in utm_*.
+	Remove []string `json:"remove,omitempty" yaml:"remove,omitempty" toml:"remove,omitempty"`
+	// Rename maps request parameter names to the names used in the target.
+	Rename map[string]string `json:"rename,omitempty" yaml:"rename,omitempty" toml:"rename,omitempty"`
+	// Add sets parameters on the target, replacing any existing value.
+	Add map[string]string `json:"add,omitempty" yaml:"add,omitempty" toml:"add,omitempty"`
+}
+
+// Conditions is a set of request matchers that must all match, keyed by
+// matcher name as in Caddy's JSON config (method, header, query, remote_ip,
+// client_ip, expression, ...), plus the built-in cookie matcher.
+type Conditions map[string]any
+
+// ExactRule is the long form of an Exact entry, for exact rules that need
+// options.
+type ExactRule struct {
+	From        string `json:"from" yaml:"from" toml:"from"`
+	To          string `json:"to" yaml:"to" toml:"to"`
+	RuleOptions `yaml:",inline"`
 }
 
 type PrefixRule struct {
-	From string `json:"from" yaml:"from" toml:"from"`
-	To   string `json:"to"      yaml:"to"      toml:"to"`
+	From        string `json:"from" yaml:"from" toml:"from"`
+	To          string `json:"to"      yaml:"to"      toml:"to"`
+	RuleOptions `yaml:",inline"`
 }
 
 type RegexRule struct {
-	Pattern string
-	To      string
+	Pattern     string
+	To          string
+	RuleOptions `yaml:",inline"`
+}
+
+// PathRule redirects paths matching a routing-style template such as
+// /blog/{year:int}/{slug}. Parameters can be used in To as {name}.
+type PathRule struct {
+	Pattern     string `json:"pattern" yaml:"pattern" toml:"pattern"`
+	To          string `json:"to" yaml:"to" toml:"to"`
+	RuleOptions `yaml:",inline"`
+}
+
+// snapshot is the immutable compiled form of a Redirector's rules. A new one
+// is built on every Provision and published atomically, so requests in flight
+// keep using the rules they started with.
+type snapshot struct {
+	hosts   []compiledHostBlock
+	index   *hostIndex
+	cascade bool
+	now     func() time.Time
+	starts  *pendingStarts
 }
 
 type compiledHostBlock struct {
-	matchAll      bool
-	suffix        string
-	exactHost     string
-	toHost        string
-	status        int
-	exactPaths    map[string]string
-	prefixBuckets map[string][]PrefixRule
-	regexRules    []compiledRegexRule
+	pattern    string
+	hostRe     *regexp.Regexp
+	toHost     *template
+	conds      caddyhttp.MatcherSet
+	window     *window
+	locale     *localeConfig
+	order      []ruleKind
+	levels     []int // rule priorities, highest first
+	exactPaths map[string][]*compiledRule
+	prefixes   *prefixTree
+	regex      *regexIndex
+	paths      *pathTree
+}
+
+// compiledRule is the part shared by all compiled rule types.
+type compiledRule struct {
+	to          *template
+	toHost      *template // set by chain flattening, replaces the block's to_host
+	action      ruleAction
+	status      int
+	priority    int
+	split       *split // weighted targets, to is the first of them
+	window      *window
+	promoteAt   time.Time // when status switches to its permanent code
+	response    *staticResponse
+	conds       caddyhttp.MatcherSet
+	query       *queryMatch
+	queryPolicy *queryPolicy
+}
+
+type compiledPrefixRule struct {
+	compiledRule
+	from string
 }
 
 type compiledRegexRule struct {
-	re *regexp.Regexp
-	to string
+	compiledRule
+	re   *regexp.Regexp
+	lits regexLiterals
+}
+
+// match carries the per-request state shared by the rule matchers.
+type match struct {
+	req        *http.Request
+	path       string
+	hostGroups []string
+	pathVars   []string
+	rest       string       // remainder after a matched prefix
+	level      int          // priority of the rules being evaluated
+	cookie     *http.Cookie // set with the response, for sticky variants
+	locales    *localeConfig
+	loc        string   // locale, resolved on first use
+	vary       []string // Vary header names, for negotiated locales and sticky variants
+	clock      func() time.Time
+	at         time.Time // request time, read from clock on first use
+	query      url.Values
+	repl       *caddy.Replacer
+}
+
+// queryValues parses the request query on first use.
+func (m *match) queryValues() url.Values {
+	if m.query == nil {
+		m.query = m.req.URL.Query()
+	}
+	return m.query
+}
+
+// addVary adds header names to the Vary header of the response.
+func (m *match) addVary(names ...string) {
+	for _, n := range names {
+		if !slices.Contains(m.vary, n) {
+			m.vary = append(m.vary, n)
+		}
+	}
+}
+
+func (cr *compiledRule) hasQuery() bool { return cr.query != nil }
+
+// accepts reports whether the rule belongs to the priority level being
+// evaluated, is within its time window, doesn't add a second locale to the
+// path and its query requirement and conditions hold for the request.
+func (cr *compiledRule) accepts(m *match) (bool, error) {
+	if cr.priority != m.level {
+		return false, nil
+	}
+	if cr.window != nil && cr.window.skips(m.now()) {
+		return false, nil
+	}
+	if m.localized(cr) {
+		return false, nil
+	}
+	if cr.query != nil && !cr.query.matches(m.queryValues()) {
+		return false, nil
+	}
+	return applies(cr.conds, m.req)
 }
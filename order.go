@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ruleKind is a rule type. A host block evaluates its rule types in the order
+// given by its order setting, which defaults to defaultOrder.
+type ruleKind int
+
+const (
+	kindExact ruleKind = iota
+	kindPath
+	kindPrefix
+	kindRegex
+)
+
+var (
+	ruleKindNames = []string{"exact", "path", "prefix", "regex"}
+	defaultOrder  = []ruleKind{kindExact, kindPath, kindPrefix, kindRegex}
+)
+
+// match runs the rules of kind k. A switch instead of a table of functions
+// lets the match of a request stay on the stack.
+func (k ruleKind) match(block *compiledHostBlock, m *match) (string, *compiledRule, error) {
+	switch k {
+	case kindExact:
+		return matchExact(block, m)
+	case kindPath:
+		return matchPath(block, m)
+	case kindPrefix:
+		return matchPrefix(block, m)
+	default:
+		return matchRegex(block, m)
+	}
+}
+
+func (k ruleKind) String() string { return ruleKindNames[k] }
+
+// parseOrder turns a host's order setting into rule kinds. Types that are not
+// listed follow the listed ones in their default order.
+func parseOrder(names []string) ([]ruleKind, error) {
+	if len(names) == 0 {
+		return defaultOrder, nil
+	}
+
+	order := make([]ruleKind, 0, len(defaultOrder))
+	for _, name := range names {
+		i := slices.Index(ruleKindNames, name)
+		if i < 0 {
+			return nil, fmt.Errorf("order: unknown rule type %q, must be exact, path, prefix or regex", name)
+		}
+		if slices.Contains(order, ruleKind(i)) {
+			return nil, fmt.Errorf("order: rule type %q listed twice", name)
+		}
+		order = append(order, ruleKind(i))
+	}
+	for _, k := range defaultOrder {
+		if !slices.Contains(order, k) {
+			order = append(order, k)
+		}
+	}
+	return order, nil
+}
+
+// addLevel records the priority of a rule of the block.
+func (block *compiledHostBlock) addLevel(priority int) {
+	i, found := slices.BinarySearchFunc(block.levels, priority, func(l, p int) int { return p - l })
+	if !found {
+		block.levels = slices.Insert(block.levels, i, priority)
+	}
+}
+
+// before reports whether a rule of kind a with priority pa is offered a
+// request before a rule of kind b with priority pb.
+func (block *compiledHostBlock) before(a ruleKind, pa int, b ruleKind, pb int) bool {
+	if pa != pb {
+		return pa > pb
+	}
+	return slices.Index(block.order, a) < slices.Index(block.order, b)
+}
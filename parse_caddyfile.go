@@ -4,8 +4,12 @@
 package redirector
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
@@ -35,6 +39,29 @@ func (r *Redirector) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if err := parseStatus(d, r); err != nil {
 					return err
 				}
+			case "query":
+				if err := parseQueryPolicy(d, &r.Query); err != nil {
+					return err
+				}
+			case "flatten_chains":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				r.FlattenChains = true
+			case "strict":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				r.Strict = true
+			case "cascade":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				r.Cascade = true
+			case "geoip":
+				if err := parseGeoIP(d, r); err != nil {
+					return err
+				}
 			default:
 				return d.Errf("unknown directive %q in redirector", d.Val())
 			}
@@ -54,6 +81,39 @@ func parseRulesFile(d *caddyfile.Dispenser, r *Redirector) error {
 	return nil
 }
 
+// parseGeoIP reads
+//
+//	geoip <database> {
+//		check_interval <duration>
+//	}
+func parseGeoIP(d *caddyfile.Dispenser, r *Redirector) error {
+	cfg := &GeoIPConfig{}
+	if !d.Args(&cfg.Database) {
+		return d.ArgErr()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "check_interval":
+			var v string
+			if !d.Args(&v) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(v)
+			if err != nil {
+				return d.Errf("check_interval: %v", err)
+			}
+			cfg.CheckInterval = caddy.Duration(dur)
+		default:
+			return d.Errf("unknown geoip option %q", d.Val())
+		}
+	}
+	r.GeoIP = cfg
+	return nil
+}
+
 func parseStatus(d *caddyfile.Dispenser, r *Redirector) error {
 	var code string
 	if !d.Args(&code) {
@@ -62,7 +122,7 @@ func parseStatus(d *caddyfile.Dispenser, r *Redirector) error {
 
 	r.DefaultCode = parseRedirectCode(code)
 	if r.DefaultCode == 0 {
-		return d.Errf("status must be 301, 307 or 308, %s given", d.Val())
+		return d.Errf("status must be 301, 302, 303, 307 or 308, %s given", d.Val())
 	}
 	return nil
 }
@@ -96,6 +156,39 @@ func parseHost(d *caddyfile.Dispenser, r *Redirector) error {
 			if err := parseHostRegex(d, &hb); err != nil {
 				return err
 			}
+		case "path":
+			if err := parseHostPath(d, &hb); err != nil {
+				return err
+			}
+		case "match":
+			if err := parseMatch(d, &hb.Match); err != nil {
+				return err
+			}
+		case "query":
+			if err := parseQueryPolicy(d, &hb.Query); err != nil {
+				return err
+			}
+		case "action":
+			if !d.Args(&hb.Action) {
+				return d.ArgErr()
+			}
+		case "order":
+			hb.Order = d.RemainingArgs()
+			if len(hb.Order) == 0 {
+				return d.ArgErr()
+			}
+		case "active_from", "active_until", "expired":
+			if err := parseSchedule(d, &hb.Schedule); err != nil {
+				return err
+			}
+		case "promote_after":
+			if err := parsePromoteAfterArg(d, &hb.PromoteAfter); err != nil {
+				return err
+			}
+		case "locale":
+			if err := parseLocale(d, &hb.Locale); err != nil {
+				return err
+			}
 		default:
 			return d.Errf("unknown subdirective %q in host block", d.Val())
 		}
@@ -114,11 +207,21 @@ func parseHostToHost(d *caddyfile.Dispenser, hb *HostBlock) error {
 }
 
 func parseHostExact(d *caddyfile.Dispenser, hb *HostBlock) error {
-	var from, to string
-	if !d.Args(&from, &to) {
-		return d.ArgErr()
+	from, to, opts, err := parseRule(d)
+	if err != nil {
+		return err
+	}
+	if opts.empty() {
+		hb.Exact[from] = to
+		return nil
+	}
+	// ExactRules are tried before Exact, so a plain rule declared earlier for
+	// the same path moves along to keep its place ahead of this one.
+	if prev, ok := hb.Exact[from]; ok {
+		hb.ExactRules = append(hb.ExactRules, ExactRule{From: from, To: prev})
+		delete(hb.Exact, from)
 	}
-	hb.Exact[from] = to
+	hb.ExactRules = append(hb.ExactRules, ExactRule{From: from, To: to, RuleOptions: opts})
 	return nil
 }
 
@@ -130,35 +233,364 @@ func parseHostStatus(d *caddyfile.Dispenser, hb *HostBlock) error {
 
 	hb.Status = parseRedirectCode(code)
 	if hb.Status == 0 {
-		return d.Errf("status must be 301, 307 or 308, %s given", d.Val())
+		return d.Errf("status must be 301, 302, 303, 307 or 308, %s given", d.Val())
 	}
 	return nil
 }
 
 func parseHostPrefix(d *caddyfile.Dispenser, hb *HostBlock) error {
-	var from, to string
-	if !d.Args(&from, &to) {
+	from, to, opts, err := parseRule(d)
+	if err != nil {
+		return err
+	}
+	hb.Prefix = append(hb.Prefix, PrefixRule{From: from, To: to, RuleOptions: opts})
+	return nil
+}
+
+func parseHostRegex(d *caddyfile.Dispenser, hb *HostBlock) error {
+	pat, to, opts, err := parseRule(d)
+	if err != nil {
+		return err
+	}
+	hb.Regex = append(hb.Regex, RegexRule{Pattern: pat, To: to, RuleOptions: opts})
+	return nil
+}
+
+func parseHostPath(d *caddyfile.Dispenser, hb *HostBlock) error {
+	pat, to, opts, err := parseRule(d)
+	if err != nil {
+		return err
+	}
+	hb.Path = append(hb.Path, PathRule{Pattern: pat, To: to, RuleOptions: opts})
+	return nil
+}
+
+// parseRule reads a rule line of the form
+//
+//	<from> [<to>] [{ <options> }]
+//
+// The target may only be omitted for rules whose action is a response or that
+// list weighted targets in their options.
+func parseRule(d *caddyfile.Dispenser) (from, to string, opts RuleOptions, err error) {
+	if !d.NextArg() {
+		return "", "", opts, d.ArgErr()
+	}
+	from = d.Val()
+	if d.NextArg() {
+		to = d.Val()
+	}
+	if d.NextArg() {
+		return "", "", opts, d.ArgErr()
+	}
+
+	if err := parseRuleOptions(d, &opts); err != nil {
+		return "", "", opts, err
+	}
+	if _, respond := responseActions[opts.Action]; to == "" && !respond && len(opts.Targets) == 0 {
+		return "", "", opts, d.Errf("rule %s needs a target", from)
+	}
+	return from, to, opts, nil
+}
+
+// parseRuleOptions reads the optional block following a rule line.
+func parseRuleOptions(d *caddyfile.Dispenser, opts *RuleOptions) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "match":
+			if err := parseMatch(d, &opts.Match); err != nil {
+				return err
+			}
+		case "status":
+			var code string
+			if !d.Args(&code) {
+				return d.ArgErr()
+			}
+			if opts.Status = parseRedirectCode(code); opts.Status == 0 {
+				return d.Errf("status must be 301, 302, 303, 307 or 308, %s given", d.Val())
+			}
+		case "query":
+			if err := parseQueryPolicy(d, &opts.Query); err != nil {
+				return err
+			}
+		case "action", "body", "body_file", "content_type":
+			field := map[string]*string{
+				"action":       &opts.Action,
+				"body":         &opts.Body,
+				"body_file":    &opts.BodyFile,
+				"content_type": &opts.ContentType,
+			}[d.Val()]
+			if !d.Args(field) {
+				return d.ArgErr()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "active_from", "active_until", "expired":
+			if err := parseSchedule(d, &opts.Schedule); err != nil {
+				return err
+			}
+		case "promote_after":
+			if err := parsePromoteAfterArg(d, &opts.PromoteAfter); err != nil {
+				return err
+			}
+		case "ignore_extra_query":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			opts.IgnoreExtraQuery = true
+		case "target":
+			var wt WeightedTarget
+			var weight string
+			if !d.Args(&wt.To, &weight) {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(weight)
+			if err != nil {
+				return d.Errf("target weight must be an integer, %s given", weight)
+			}
+			wt.Weight = n
+			if d.NextArg() {
+				wt.Name = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			opts.Targets = append(opts.Targets, wt)
+		case "sticky":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			opts.Sticky = strings.Join(args, " ")
+		case "priority":
+			var p string
+			if !d.Args(&p) {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return d.Errf("priority must be an integer, %s given", p)
+			}
+			opts.Priority = n
+		default:
+			return d.Errf("unknown rule option %q", d.Val())
+		}
+	}
+	return nil
+}
+
+// parseSchedule reads one of
+//
+//	active_from <timestamp>
+//	active_until <timestamp>
+//	expired pass|<response action>|<target>
+func parseSchedule(d *caddyfile.Dispenser, s *Schedule) error {
+	key := d.Val()
+	var val string
+	if !d.Args(&val) {
+		return d.ArgErr()
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	switch key {
+	case "active_from":
+		s.ActiveFrom = Timestamp(val)
+	case "active_until":
+		s.ActiveUntil = Timestamp(val)
+	default:
+		s.Expired = val
+	}
+	return nil
+}
+
+// parsePromoteAfterArg reads
+//
+//	promote_after <duration>|<timestamp>
+func parsePromoteAfterArg(d *caddyfile.Dispenser, after *Timestamp) error {
+	var val string
+	if !d.Args(&val) {
 		return d.ArgErr()
 	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	*after = Timestamp(val)
+	return nil
+}
 
-	hb.Prefix = append(hb.Prefix, PrefixRule{From: from, To: to})
+// parseLocale reads
+//
+//	locale <locales...> {
+//		default <locale>
+//		cookie <name>
+//	}
+func parseLocale(d *caddyfile.Dispenser, lc **LocaleConfig) error {
+	l := &LocaleConfig{Locales: d.RemainingArgs()}
+	if len(l.Locales) == 0 {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var field *string
+		switch d.Val() {
+		case "default":
+			field = &l.Default
+		case "cookie":
+			field = &l.Cookie
+		default:
+			return d.Errf("unknown locale option %q", d.Val())
+		}
+		if !d.Args(field) {
+			return d.ArgErr()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	*lc = l
 	return nil
 }
 
-func parseHostRegex(d *caddyfile.Dispenser, hb *HostBlock) error {
-	var pat, to string
-	if !d.Args(&pat, &to) {
+// parseQueryPolicy reads
+//
+//	query [drop|preserve|merge] {
+//		remove <keys...>
+//		rename <from>=<to>...
+//		add <key>=<value>...
+//	}
+func parseQueryPolicy(d *caddyfile.Dispenser, qp **QueryPolicy) error {
+	p := &QueryPolicy{}
+	if d.NextArg() {
+		p.Mode = d.Val()
+	}
+	if d.NextArg() {
 		return d.ArgErr()
 	}
 
-	hb.Regex = append(hb.Regex, RegexRule{Pattern: pat, To: to})
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "remove":
+			keys := d.RemainingArgs()
+			if len(keys) == 0 {
+				return d.ArgErr()
+			}
+			p.Remove = append(p.Remove, keys...)
+		case "rename", "add":
+			field := &p.Rename
+			if d.Val() == "add" {
+				field = &p.Add
+			}
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			for _, arg := range args {
+				k, v, ok := strings.Cut(arg, "=")
+				if !ok || k == "" {
+					return d.Errf("expected key=value, %q given", arg)
+				}
+				if *field == nil {
+					*field = make(map[string]string)
+				}
+				(*field)[k] = v
+			}
+		default:
+			return d.Errf("unknown query option %q", d.Val())
+		}
+	}
+
+	*qp = p
+	return nil
+}
+
+// parseMatch reads request conditions written like the body of a Caddyfile
+// named matcher, either inline (match method GET) or as a block. A backtick
+// quoted token is shorthand for an expression matcher.
+func parseMatch(d *caddyfile.Dispenser, conds *Conditions) error {
+	tokens := make(map[string][]caddyfile.Token)
+	var names []string
+	for nesting := d.Nesting(); d.NextArg() || d.NextBlock(nesting); {
+		name := d.Val()
+		var segment []caddyfile.Token
+		if d.Token().Quoted() {
+			expr := d.Token().Clone()
+			expr.Text = "expression"
+			name, segment = "expression", []caddyfile.Token{expr, d.Token()}
+		} else {
+			segment = d.NextSegment()
+		}
+		if _, ok := tokens[name]; !ok {
+			names = append(names, name)
+		}
+		tokens[name] = append(tokens[name], segment...)
+	}
+	if len(names) == 0 {
+		return d.ArgErr()
+	}
+
+	if *conds == nil {
+		*conds = make(Conditions, len(names))
+	}
+	for _, name := range names {
+		v, err := unmarshalMatcher(name, tokens[name])
+		if err != nil {
+			return d.Errf("match %s: %v", name, err)
+		}
+		(*conds)[name] = v
+	}
 	return nil
 }
 
+// unmarshalMatcher parses the Caddyfile tokens of one matcher into its JSON
+// form, so the result is the same as writing the condition in a rule file.
+func unmarshalMatcher(name string, tokens []caddyfile.Token) (any, error) {
+	var mod any
+	if name == cookieMatcherName {
+		cm := make(cookieMatcher)
+		if err := cm.UnmarshalCaddyfile(caddyfile.NewDispenser(tokens)); err != nil {
+			return nil, err
+		}
+		mod = cm
+	} else if name == geoMatcherName {
+		gm := &geoMatcher{}
+		if err := gm.UnmarshalCaddyfile(caddyfile.NewDispenser(tokens)); err != nil {
+			return nil, err
+		}
+		mod = gm
+	} else {
+		info, err := caddy.GetModule("http.matchers." + name)
+		if err != nil {
+			return nil, err
+		}
+		unm, ok := info.New().(caddyfile.Unmarshaler)
+		if !ok {
+			return nil, fmt.Errorf("matcher %q has no Caddyfile syntax", name)
+		}
+		if err := unm.UnmarshalCaddyfile(caddyfile.NewDispenser(tokens)); err != nil {
+			return nil, err
+		}
+		mod = unm
+	}
+
+	raw, err := json.Marshal(mod)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
 func parseRedirectCode(code string) int {
 	switch code {
 	case "301":
 		return 301
+	case "302":
+		return 302
+	case "303":
+		return 303
 	case "307":
 		return 307
 	case "308":
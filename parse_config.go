@@ -5,44 +5,85 @@ package redirector
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	toml "github.com/pelletier/go-toml/v2"
 	yaml "gopkg.in/yaml.v3"
 )
 
-func (r *Redirector) loadExternalRules() error {
-	for _, rf := range r.RulesFiles {
-		abs := resolvePath(r.baseDir, rf.Path)
+// loadHosts merges the rule files into a copy of the configured host blocks.
+// Every source is validated on its own, so problems are reported with the file
+// they come from; all of them are returned joined. Exact rules replaced while
+// merging are reported to l, which may be nil.
+func (r *Redirector) loadHosts(l *linter) ([]HostBlock, error) {
+	v := &validator{}
+	if r.DefaultCode != 0 {
+		if err := checkRedirectCode(r.DefaultCode); err != nil {
+			v.errs = append(v.errs, err)
+		}
+	}
+	v.hosts(r.Hosts)
 
-		data, err := os.ReadFile(abs)
+	hosts := cloneHosts(r.Hosts)
+	for _, rf := range r.RulesFiles {
+		er, err := r.readRulesFile(rf)
 		if err != nil {
-			return err
+			v.errs = append(v.errs, err)
+			continue
 		}
 
-		format := pickFormat(rf.Format, abs)
+		v.where = fmt.Sprintf("rule_file %q: ", rf.Path)
+		v.hosts(er.Hosts)
+		l.overrides(hosts, er.Hosts, rf.Path)
+		hosts = mergeHosts(hosts, er.Hosts)
+	}
+	return hosts, errors.Join(v.errs...)
+}
 
-		var er ExternalRules
-		if err := unmarshalByFormat(format, data, &er, rf.Path); err != nil {
-			return err
-		}
+func (r *Redirector) readRulesFile(rf RulesFile) (ExternalRules, error) {
+	abs := resolvePath(r.baseDir, rf.Path)
 
-		r.Hosts = mergeHosts(r.Hosts, er.Hosts)
+	var er ExternalRules
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return er, err
 	}
-	return nil
+
+	err = unmarshalByFormat(pickFormat(rf.Format, abs), data, &er, rf.Path)
+	return er, err
+}
+
+// cloneHosts copies the host blocks deeply enough that merging rule files into
+// the copy never mutates the configured blocks, so provisioning is repeatable.
+func cloneHosts(src []HostBlock) []HostBlock {
+	out := make([]HostBlock, len(src))
+	for i, hb := range src {
+		out[i] = hb
+		out[i].Match = maps.Clone(hb.Match)
+		out[i].Order = slices.Clone(hb.Order)
+		out[i].Exact = maps.Clone(hb.Exact)
+		out[i].ExactRules = slices.Clone(hb.ExactRules)
+		out[i].Prefix = slices.Clone(hb.Prefix)
+		out[i].Regex = slices.Clone(hb.Regex)
+		out[i].Path = slices.Clone(hb.Path)
+	}
+	return out
 }
 
 func mergeHosts(dst, src []HostBlock) []HostBlock {
 	index := make(map[string]int, len(dst))
 	for i, hb := range dst {
-		index[strings.ToLower(hb.Pattern)] = i
+		index[hostKey(hb.Pattern)] = i
 	}
 
 	for _, s := range src {
-		key := strings.ToLower(s.Pattern)
+		key := hostKey(s.Pattern)
 		if i, ok := index[key]; ok {
 			mergeHostBlock(&dst[i], s)
 			continue
@@ -107,6 +148,18 @@ func mergeHostBlock(dst *HostBlock, s HostBlock) {
 	if s.ToHost != "" {
 		dst.ToHost = s.ToHost
 	}
+	if len(s.Match) != 0 {
+		dst.Match = s.Match
+	}
+	if s.Query != nil {
+		dst.Query = s.Query
+	}
+	if s.Action != "" {
+		dst.Action = s.Action
+	}
+	if len(s.Order) != 0 {
+		dst.Order = s.Order
+	}
 	if len(s.Exact) != 0 {
 		if dst.Exact == nil {
 			dst.Exact = make(map[string]string, len(s.Exact))
@@ -115,10 +168,16 @@ func mergeHostBlock(dst *HostBlock, s HostBlock) {
 			dst.Exact[k] = v
 		}
 	}
+	if len(s.ExactRules) != 0 {
+		dst.ExactRules = append(dst.ExactRules, s.ExactRules...)
+	}
 	if len(s.Prefix) != 0 {
 		dst.Prefix = append(dst.Prefix, s.Prefix...)
 	}
 	if len(s.Regex) != 0 {
 		dst.Regex = append(dst.Regex, s.Regex...)
 	}
+	if len(s.Path) != 0 {
+		dst.Path = append(dst.Path, s.Path...)
+	}
 }
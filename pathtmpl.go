@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pathTree matches request paths against routing-style templates such as
+// /blog/{year:int}/{slug} or /docs/{rest...}. Templates are split into
+// segments and stored in a trie; a lookup walks the path segment by segment
+// and prefers static segments over parameters and parameters over a trailing
+// catch-all, backtracking when a more specific branch dead-ends.
+type pathTree struct {
+	root pathNode
+}
+
+type pathNode struct {
+	static   map[string]*pathNode
+	params   []*paramEdge
+	catchAll []*compiledPathRule
+	rules    []*compiledPathRule
+}
+
+type paramEdge struct {
+	seg  pathSegment
+	node *pathNode
+}
+
+type compiledPathRule struct {
+	compiledRule
+	pattern string
+	vars    []string
+}
+
+type segKind int
+
+const (
+	segStatic segKind = iota
+	segString
+	segInt
+	segAlpha
+	segAlnum
+	segCatchAll
+)
+
+var segKinds = map[string]segKind{
+	"":       segString,
+	"string": segString,
+	"int":    segInt,
+	"alpha":  segAlpha,
+	"alnum":  segAlnum,
+}
+
+// pathSegment is one parsed template segment. A parameter may be surrounded by
+// literal text within its segment, as in post-{id:int}.html.
+type pathSegment struct {
+	kind   segKind
+	lit    string
+	prefix string
+	suffix string
+	name   string
+}
+
+// parsePathTemplate splits a template into segments and returns them together
+// with the parameter names in order of appearance.
+func parsePathTemplate(pattern string) ([]pathSegment, []string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, nil, fmt.Errorf("path template %q must start with /", pattern)
+	}
+
+	raw := strings.Split(pattern[1:], "/")
+	segs := make([]pathSegment, 0, len(raw))
+	var names []string
+	for i, s := range raw {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			if strings.IndexByte(s, '}') >= 0 {
+				return nil, nil, fmt.Errorf("path template %q: unbalanced braces in segment %q", pattern, s)
+			}
+			segs = append(segs, pathSegment{kind: segStatic, lit: s})
+			continue
+		}
+
+		end := strings.IndexByte(s, '}')
+		if end < open || strings.IndexByte(s[end+1:], '{') >= 0 || strings.IndexByte(s[end+1:], '}') >= 0 {
+			return nil, nil, fmt.Errorf("path template %q: segment %q must contain exactly one {param}", pattern, s)
+		}
+
+		seg := pathSegment{prefix: s[:open], suffix: s[end+1:]}
+		name, typ, _ := strings.Cut(s[open+1:end], ":")
+		if rest, ok := strings.CutSuffix(name, "..."); ok {
+			if i != len(raw)-1 || seg.prefix != "" || seg.suffix != "" || typ != "" {
+				return nil, nil, fmt.Errorf("path template %q: {%s} must be the whole last segment", pattern, name)
+			}
+			name, seg.kind = rest, segCatchAll
+		} else {
+			kind, ok := segKinds[typ]
+			if !ok {
+				return nil, nil, fmt.Errorf("path template %q: unknown parameter type %q", pattern, typ)
+			}
+			seg.kind = kind
+		}
+
+		if name == "" {
+			return nil, nil, fmt.Errorf("path template %q: parameter without a name", pattern)
+		}
+		if slices.Contains(names, name) {
+			return nil, nil, fmt.Errorf("path template %q: duplicate parameter %q", pattern, name)
+		}
+		seg.name = name
+		names = append(names, name)
+		segs = append(segs, seg)
+	}
+	return segs, names, nil
+}
+
+// insert adds rule under its parsed segments. Templates with the same shape
+// are kept in declaration order, so the first declared rule whose conditions
+// apply wins.
+func (t *pathTree) insert(segs []pathSegment, rule *compiledPathRule) {
+	n := &t.root
+	for _, seg := range segs {
+		switch seg.kind {
+		case segStatic:
+			c := n.static[seg.lit]
+			if c == nil {
+				if n.static == nil {
+					n.static = make(map[string]*pathNode)
+				}
+				c = &pathNode{}
+				n.static[seg.lit] = c
+			}
+			n = c
+		case segCatchAll:
+			n.catchAll = append(n.catchAll, rule)
+			return
+		default:
+			n = n.param(seg)
+		}
+	}
+	n.rules = append(n.rules, rule)
+}
+
+// param returns the child for seg, adding an edge if needed. Edges are kept
+// ordered from most to least specific: more literal text first, then typed
+// parameters before plain strings, then declaration order.
+func (n *pathNode) param(seg pathSegment) *pathNode {
+	for _, e := range n.params {
+		if e.seg.kind == seg.kind && e.seg.prefix == seg.prefix && e.seg.suffix == seg.suffix {
+			return e.node
+		}
+	}
+
+	e := &paramEdge{seg: seg, node: &pathNode{}}
+	n.params = append(n.params, e)
+	slices.SortStableFunc(n.params, func(a, b *paramEdge) int {
+		la := len(a.seg.prefix) + len(a.seg.suffix)
+		lb := len(b.seg.prefix) + len(b.seg.suffix)
+		if la != lb {
+			return lb - la
+		}
+		return typedRank(a.seg.kind) - typedRank(b.seg.kind)
+	})
+	return e.node
+}
+
+func typedRank(k segKind) int {
+	if k == segString {
+		return 1
+	}
+	return 0
+}
+
+// pathAccept is called for every rule matching a path, most specific first,
+// with the parameter values in template order. Returning true ends the lookup.
+type pathAccept func(rule *compiledPathRule, vals []string) bool
+
+// lookup matches path (starting with / or empty once all segments are
+// consumed) below n and offers the matching rules to accept.
+func (n *pathNode) lookup(path string, vals []string, accept pathAccept) bool {
+	if path == "" {
+		for _, r := range n.rules {
+			if accept(r, vals) {
+				return true
+			}
+		}
+		return false
+	}
+
+	seg, after := path[1:], ""
+	if i := strings.IndexByte(seg, '/'); i >= 0 {
+		seg, after = seg[:i], seg[i:]
+	}
+
+	if c := n.static[seg]; c != nil && c.lookup(after, vals, accept) {
+		return true
+	}
+
+	for _, e := range n.params {
+		if val, ok := e.seg.match(seg); ok && e.node.lookup(after, append(vals, val), accept) {
+			return true
+		}
+	}
+
+	for _, r := range n.catchAll {
+		if accept(r, append(vals, path[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *pathTree) lookup(path string, accept pathAccept) {
+	if !strings.HasPrefix(path, "/") {
+		return
+	}
+	var buf [8]string
+	t.root.lookup(path, buf[:0], accept)
+}
+
+// match checks a single path segment against a parameter segment and returns
+// the parameter value.
+func (s pathSegment) match(seg string) (string, bool) {
+	if len(seg) <= len(s.prefix)+len(s.suffix) || !strings.HasPrefix(seg, s.prefix) || !strings.HasSuffix(seg, s.suffix) {
+		return "", false
+	}
+	val := seg[len(s.prefix) : len(seg)-len(s.suffix)]
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		digit := c >= '0' && c <= '9'
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		switch {
+		case s.kind == segInt && !digit,
+			s.kind == segAlpha && !letter,
+			s.kind == segAlnum && !digit && !letter:
+			return "", false
+		}
+	}
+	return val, true
+}
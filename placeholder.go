@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// placeholderShorthands are the Caddyfile shorthands for common placeholders.
+// The Caddyfile adapter expands them before the module sees its config; they
+// are mapped here as well so rule files can use the same spelling.
+var placeholderShorthands = map[string]string{
+	"host":   "http.request.host",
+	"method": "http.request.method",
+	"path":   "http.request.uri.path",
+	"query":  "http.request.uri.query",
+	"scheme": "http.request.scheme",
+	"uri":    "http.request.uri",
+}
+
+var placeholderPrefixShorthands = [][2]string{
+	{"cookie.", "http.request.cookie."},
+	{"header.", "http.request.header."},
+	{"query.", "http.request.uri.query."},
+	{"vars.", "http.vars."},
+}
+
+// isPlaceholderName reports whether name can be a Caddy placeholder. Anything
+// else between braces is kept as literal text.
+func isPlaceholderName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t{}$")
+}
+
+func placeholderKey(name string) string {
+	if key, ok := placeholderShorthands[name]; ok {
+		return key
+	}
+	for _, sh := range placeholderPrefixShorthands {
+		if rest, ok := strings.CutPrefix(name, sh[0]); ok {
+			return sh[1] + rest
+		}
+	}
+	return name
+}
+
+// placeholder looks up a Caddy placeholder for the request. Unknown
+// placeholders, or requests without a replacer, report false.
+func (m *match) placeholder(key string) (string, bool) {
+	repl := m.replacer()
+	if repl == nil {
+		return "", false
+	}
+	return repl.GetString(key)
+}
+
+// replacer returns the request's replacer, or nil if it has none.
+func (m *match) replacer() *caddy.Replacer {
+	if m.repl == nil {
+		m.repl, _ = m.req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	}
+	return m.repl
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// promotedCodes maps the temporary redirect codes to the permanent ones they
+// are promoted to.
+var promotedCodes = map[int]int{
+	http.StatusFound:             http.StatusMovedPermanently,
+	http.StatusTemporaryRedirect: http.StatusPermanentRedirect,
+}
+
+// promoteKeyPrefix is where the first provision time of rules promoted after
+// a duration is kept in Caddy's storage.
+const promoteKeyPrefix = "redirector/promote/"
+
+// parsePromoteAfter splits promote_after into a duration since first
+// provision, such as 14d or 36h, or a fixed point in time.
+func parsePromoteAfter(s Timestamp) (time.Duration, time.Time, error) {
+	if d, err := caddy.ParseDuration(string(s)); err == nil {
+		if d <= 0 {
+			return 0, time.Time{}, fmt.Errorf("promote_after must be positive, %s given", s)
+		}
+		return d, time.Time{}, nil
+	}
+	t, err := parseTimestamp(s)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("promote_after must be a duration such as 14d or a timestamp, %q given", string(s))
+	}
+	return 0, t, nil
+}
+
+// promotion returns when a rule or host block identified by key switches to
+// its permanent code. Durations count from the first provision that saw the
+// key with the same promote_after and served a request, which is kept in
+// storage across restarts.
+func (c *compiler) promotion(key string, after Timestamp) (time.Time, error) {
+	d, at, err := parsePromoteAfter(after)
+	if err != nil || d == 0 {
+		return at, err
+	}
+	first, err := c.firstProvision(key + " " + string(after))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("promote_after: %w", err)
+	}
+	return first.Add(d), nil
+}
+
+// firstProvision returns the stored first provision time of key, or now. A
+// new time is only kept in pending until the snapshot serves a request.
+func (c *compiler) firstProvision(key string) (time.Time, error) {
+	if c.storage == nil {
+		c.storage = c.ctx.Storage()
+	}
+	sum := sha256.Sum256([]byte(key))
+	skey := promoteKeyPrefix + hex.EncodeToString(sum[:16])
+	if c.starts != nil {
+		if at, ok := c.starts.keys[skey]; ok {
+			return at, nil
+		}
+	}
+
+	b, err := c.storage.Load(c.ctx, skey)
+	switch {
+	case err == nil:
+		return time.Parse(time.RFC3339Nano, string(b))
+	case !errors.Is(err, fs.ErrNotExist):
+		return time.Time{}, err
+	}
+
+	now := c.now().UTC()
+	if c.starts == nil {
+		c.starts = &pendingStarts{ctx: c.ctx, storage: c.storage, keys: map[string]time.Time{}}
+	}
+	c.starts.keys[skey] = now
+	return now, nil
+}
+
+// pendingStarts are the first provision times a snapshot found nothing stored
+// for. They are written when the snapshot serves its first request, so a
+// configuration that is rejected or only checked with caddy validate doesn't
+// start the clock of its promotions.
+type pendingStarts struct {
+	once    sync.Once
+	ctx     caddy.Context
+	storage certmagic.Storage
+	keys    map[string]time.Time
+}
+
+// store writes the pending times in the background, once.
+func (p *pendingStarts) store() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() { go p.write() })
+}
+
+func (p *pendingStarts) write() {
+	for key, at := range p.keys {
+		// An earlier snapshot may have served first.
+		if p.storage.Exists(p.ctx, key) {
+			continue
+		}
+		if err := p.storage.Store(p.ctx, key, []byte(at.Format(time.RFC3339Nano))); err != nil {
+			p.ctx.Logger().Error("storing promotion start", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// promote applies an inherited or own promotion to a compiled rule once its
+// status is known. An own promote_after needs a temporary redirect; inherited
+// ones leave other rules alone.
+func (cr *compiledRule) promote(at time.Time, own bool) error {
+	if _, ok := promotedCodes[cr.status]; ok && cr.action == actionRedirect {
+		cr.promoteAt = at
+		return nil
+	}
+	if own {
+		return fmt.Errorf("promote_after needs a redirect with status 302 or 307")
+	}
+	return nil
+}
+
+// statusFor returns the status the redirect of a request is sent with.
+func (cr *compiledRule) statusFor(m *match) int {
+	if !cr.promoteAt.IsZero() && !m.now().Before(cr.promoteAt) {
+		return promotedCodes[cr.status]
+	}
+	return cr.status
+}
@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// queryMatch is the query part of an exact or prefix From such as
+// /view.asp?cat=3&item=9. Parameters are compared decoded and regardless of
+// their order; the values of a repeated parameter are compared as a set.
+type queryMatch struct {
+	params url.Values // values sorted per key
+	extra  bool       // allow parameters that are not listed in params
+}
+
+// splitQuery separates the path of a From from its query requirement. A From
+// without ? has no requirement and matches any query.
+func splitQuery(from string, ignoreExtra bool) (string, *queryMatch, error) {
+	path, rawQuery, ok := strings.Cut(from, "?")
+	if !ok {
+		return from, nil, nil
+	}
+
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, vals := range params {
+		slices.Sort(vals)
+	}
+	return path, &queryMatch{params: params, extra: ignoreExtra}, nil
+}
+
+// equal reports whether q and o accept the same queries.
+func (q *queryMatch) equal(o *queryMatch) bool {
+	if q == nil || o == nil {
+		return q == o
+	}
+	return q.extra == o.extra && maps.EqualFunc(q.params, o.params, slices.Equal)
+}
+
+func (q *queryMatch) matches(got url.Values) bool {
+	if !q.extra && len(got) != len(q.params) {
+		return false
+	}
+	for key, want := range q.params {
+		have := got[key]
+		if len(have) != len(want) {
+			return false
+		}
+		if len(have) > 1 {
+			have = slices.Sorted(slices.Values(have))
+		}
+		if !slices.Equal(have, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// insertByQuery adds rule to the candidates of one path or prefix. Rules with
+// a query requirement are more specific and go before those without one;
+// otherwise declaration order is kept.
+func insertByQuery[R interface{ hasQuery() bool }](rules []R, rule R) []R {
+	i := len(rules)
+	if rule.hasQuery() {
+		if j := slices.IndexFunc(rules, func(r R) bool { return !r.hasQuery() }); j >= 0 {
+			i = j
+		}
+	}
+	return slices.Insert(rules, i, rule)
+}
+
+const (
+	queryDrop     = "drop"
+	queryPreserve = "preserve"
+	queryMerge    = "merge"
+)
+
+// queryRewrite is the policy of rewrite rules without a configured one, as in
+// Caddy's rewrite handler: the request query is kept unless the target has a
+// query. It can't be configured.
+const queryRewrite = "rewrite"
+
+var rewriteQueryPolicy = &queryPolicy{mode: queryRewrite}
+
+// queryPolicy is the compiled form of a QueryPolicy. A nil policy drops the
+// request query and leaves the target untouched.
+type queryPolicy struct {
+	mode    string
+	remove  []string
+	removes []string // prefixes from entries ending in *
+	rename  map[string]string
+	add     []string // encoded key=value pairs, sorted by key
+	addKeys []string
+}
+
+func compileQueryPolicy(qp *QueryPolicy) (*queryPolicy, error) {
+	if qp == nil {
+		return nil, nil
+	}
+
+	p := &queryPolicy{mode: strings.ToLower(strings.TrimSpace(qp.Mode)), rename: qp.Rename}
+	switch p.mode {
+	case "":
+		p.mode = queryDrop
+	case queryDrop, queryPreserve, queryMerge:
+	default:
+		return nil, fmt.Errorf("query: unknown mode %q, must be drop, preserve or merge", qp.Mode)
+	}
+
+	for _, key := range qp.Remove {
+		if prefix, ok := strings.CutSuffix(key, "*"); ok {
+			p.removes = append(p.removes, prefix)
+			continue
+		}
+		p.remove = append(p.remove, key)
+	}
+
+	p.addKeys = slices.Sorted(maps.Keys(qp.Add))
+	for _, key := range p.addKeys {
+		p.add = append(p.add, url.QueryEscape(key)+"="+url.QueryEscape(qp.Add[key]))
+	}
+
+	if p.mode == queryDrop && len(p.add) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// apply combines the query of target with the request query. The fragment of
+// the target, if any, stays at the end.
+func (p *queryPolicy) apply(target, reqQuery string) string {
+	if p == nil {
+		return target
+	}
+
+	rest, frag, hasFrag := strings.Cut(target, "#")
+	base, tq, _ := strings.Cut(rest, "?")
+	pairs := splitQueryPairs(tq)
+
+	mode := p.mode
+	if mode == queryRewrite {
+		mode = queryPreserve
+		if tq != "" {
+			mode = queryDrop
+		}
+	}
+	if mode != queryDrop {
+		targetLen := len(pairs)
+		for _, pair := range splitQueryPairs(reqQuery) {
+			key := pairKey(pair)
+			if p.removed(key) {
+				continue
+			}
+			if to, ok := p.rename[key]; ok {
+				_, val, hasVal := strings.Cut(pair, "=")
+				key, pair = to, url.QueryEscape(to)
+				if hasVal {
+					pair += "=" + val
+				}
+			}
+			if mode == queryMerge && slices.ContainsFunc(pairs[:targetLen], func(t string) bool { return pairKey(t) == key }) {
+				continue
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+
+	if len(p.add) > 0 {
+		pairs = slices.DeleteFunc(pairs, func(pair string) bool {
+			_, found := slices.BinarySearch(p.addKeys, pairKey(pair))
+			return found
+		})
+		pairs = append(pairs, p.add...)
+	}
+
+	out := base
+	if len(pairs) > 0 {
+		out += "?" + strings.Join(pairs, "&")
+	}
+	if hasFrag {
+		out += "#" + frag
+	}
+	return out
+}
+
+// equal reports whether p and o treat every query the same.
+func (p *queryPolicy) equal(o *queryPolicy) bool {
+	if p == nil || o == nil {
+		return p == o
+	}
+	return p.mode == o.mode && slices.Equal(p.remove, o.remove) && slices.Equal(p.removes, o.removes) &&
+		maps.Equal(p.rename, o.rename) && slices.Equal(p.add, o.add)
+}
+
+func (p *queryPolicy) removed(key string) bool {
+	if slices.Contains(p.remove, key) {
+		return true
+	}
+	return slices.ContainsFunc(p.removes, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// splitQueryPairs splits a raw query into its key=value pairs, keeping their
+// order and encoding.
+func splitQueryPairs(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	pairs := strings.Split(raw, "&")
+	return slices.DeleteFunc(pairs, func(pair string) bool { return pair == "" })
+}
+
+// pairKey returns the decoded key of a raw key=value pair.
+func pairKey(pair string) string {
+	key, _, _ := strings.Cut(pair, "=")
+	if k, err := url.QueryUnescape(key); err == nil {
+		return k
+	}
+	return key
+}
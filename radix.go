@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import "strings"
+
+// radixTree is a byte-level radix tree keyed by path prefixes. A lookup walks
+// the request path once, so its cost depends on the path length and not on the
+// number of keys stored in the tree.
+type radixTree[V any] struct {
+	root radixNode[V]
+}
+
+type radixNode[V any] struct {
+	label    string
+	indices  string
+	children []radixNode[V]
+	value    V
+	set      bool
+}
+
+// node returns the node stored under key, creating it and splitting edges as
+// needed. Children are stored by value to save a pointer hop per byte of a
+// lookup, so the returned node is only valid until the next insertion.
+func (t *radixTree[V]) node(key string) *radixNode[V] {
+	n := &t.root
+	for key != "" {
+		i := strings.IndexByte(n.indices, key[0])
+		if i < 0 {
+			n.indices += string(key[0])
+			n.children = append(n.children, radixNode[V]{label: key})
+			return &n.children[len(n.children)-1]
+		}
+
+		c := &n.children[i]
+		l := commonPrefixLen(key, c.label)
+		if l < len(c.label) {
+			tail := *c
+			tail.label = c.label[l:]
+			*c = radixNode[V]{
+				label:    c.label[:l],
+				indices:  string(c.label[l]),
+				children: []radixNode[V]{tail},
+			}
+		}
+
+		key = key[l:]
+		n = c
+	}
+	return n
+}
+
+// walk calls fn for every stored key that is a prefix of path, shortest first.
+func (t *radixTree[V]) walk(path string, fn func(V)) {
+	n := &t.root
+	if n.set {
+		fn(n.value)
+	}
+	for path != "" {
+		c := n.child(path[0])
+		if c == nil || !hasLabel(path, c.label) {
+			return
+		}
+
+		path = path[len(c.label):]
+		n = c
+		if n.set {
+			fn(n.value)
+		}
+	}
+}
+
+// child returns the child whose label starts with b. Most nodes have only a
+// few children, so a plain loop beats a call to strings.IndexByte.
+func (n *radixNode[V]) child(b byte) *radixNode[V] {
+	for i := 0; i < len(n.indices); i++ {
+		if n.indices[i] == b {
+			return &n.children[i]
+		}
+	}
+	return nil
+}
+
+// hasLabel reports whether path starts with label, whose first byte already
+// matched through the parent's indices.
+func hasLabel(path, label string) bool {
+	return len(path) >= len(label) && (len(label) == 1 || path[1:len(label)] == label[1:])
+}
+
+// each calls fn for every stored value.
+func (t *radixTree[V]) each(fn func(V)) {
+	var visit func(n *radixNode[V])
+	visit = func(n *radixNode[V]) {
+		if n.set {
+			fn(n.value)
+		}
+		for i := range n.children {
+			visit(&n.children[i])
+		}
+	}
+	visit(&t.root)
+}
+
+// prefixTree holds the prefix rules of a host block. Rules sharing the same
+// From are kept in declaration order, after the ones with a query requirement,
+// so a rule that doesn't apply falls through to the next one.
+type prefixTree struct {
+	radixTree[[]*compiledPrefixRule]
+}
+
+func (t *prefixTree) insert(rule *compiledPrefixRule) {
+	n := t.node(rule.from)
+	n.value, n.set = insertByQuery(n.value, rule), true
+}
+
+// longestFirst calls fn for the rules whose From is a prefix of path, longest
+// From first, until fn returns true.
+func (t *prefixTree) longestFirst(path string, fn func(*compiledPrefixRule) bool) {
+	var buf [16][]*compiledPrefixRule
+	hits := buf[:0]
+	t.walk(path, func(rules []*compiledPrefixRule) { hits = append(hits, rules) })
+	for i := len(hits) - 1; i >= 0; i-- {
+		for _, r := range hits[i] {
+			if fn(r) {
+				return
+			}
+		}
+	}
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -5,166 +5,238 @@ package redirector
 
 import (
 	"net/http"
-	"regexp"
-	"sort"
+	"net/url"
 	"strings"
+	"unicode"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 )
 
-var compiled []compiledHostBlock
-
 func init() {
-	caddy.RegisterModule(Redirector{})
+	caddy.RegisterModule(new(Redirector))
 	httpcaddyfile.RegisterHandlerDirective("redirector", parseCaddyFile)
 }
 
-func (Redirector) CaddyModule() caddy.ModuleInfo {
+func (*Redirector) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID:  "http.handlers.redirector",
 		New: func() caddy.Module { return new(Redirector) },
 	}
 }
 
-func (r *Redirector) Provision(caddy.Context) error {
+func (r *Redirector) Provision(ctx caddy.Context) error {
 	if r.DefaultCode == 0 {
 		r.DefaultCode = http.StatusPermanentRedirect
 	}
 
-	if len(r.RulesFiles) > 0 {
-		if err := r.loadExternalRules(); err != nil {
-			return err
-		}
+	lint := &linter{}
+	hosts, err := r.loadHosts(lint)
+	if err != nil {
+		return err
 	}
 
-	compiled = make([]compiledHostBlock, 0, len(r.Hosts))
-	for _, hb := range r.Hosts {
-		ch := compiledHostBlock{
-			toHost:     hb.ToHost,
-			exactPaths: hb.Exact,
-			status:     hb.Status,
-		}
-
-		p := strings.ToLower(strings.TrimSpace(hb.Pattern))
-		switch {
-		case p == "*":
-			ch.matchAll = true
-		case strings.HasPrefix(p, "*.") && len(p) > 2:
-			ch.suffix = p[1:]
-		default:
-			ch.exactHost = p
-		}
-
-		if ch.status == 0 {
-			ch.status = r.DefaultCode
-		}
-
-		for _, rr := range hb.Regex {
-			re, err := regexp.Compile(rr.Pattern)
-			if err != nil {
-				return err
-			}
-
-			ch.regexRules = append(ch.regexRules, compiledRegexRule{re: re, to: rr.To})
-		}
-
-		if len(hb.Prefix) > 0 {
-			ch.prefixBuckets = make(map[string][]PrefixRule, len(hb.Prefix))
-			for _, pr := range hb.Prefix {
-				k := bucketKey(pr.From)
-				ch.prefixBuckets[k] = append(ch.prefixBuckets[k], pr)
-			}
-
-			for k := range ch.prefixBuckets {
-				sort.SliceStable(ch.prefixBuckets[k], func(i, j int) bool {
-					return len(ch.prefixBuckets[k][i].From) > len(ch.prefixBuckets[k][j].From)
-				})
-			}
-		}
+	snap, err := compile(ctx, r, hosts)
+	if err != nil {
+		return err
+	}
 
-		compiled = append(compiled, ch)
+	lint.snapshot(snap)
+	if err := lint.report(ctx, r.Strict); err != nil {
+		return err
 	}
 
+	r.state.Store(snap)
 	return nil
 }
 
-func (r *Redirector) Validate() error { return nil }
+// Validate reports every problem of the configuration and its rule files that
+// can be found without compiling the rules. Provision runs the same checks
+// before compiling, so an instance that was provisioned is known to be valid.
+func (r *Redirector) Validate() error {
+	if r.state.Load() != nil {
+		return nil
+	}
+	_, err := r.loadHosts(nil)
+	return err
+}
 
 func (r *Redirector) ServeHTTP(w http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
-	host := strings.ToLower(req.Host)
-	path := req.URL.Path
+	snap := r.state.Load()
+	if snap == nil {
+		return next.ServeHTTP(w, req)
+	}
+	snap.starts.store()
+
+	// One match for all host blocks tried, reset for each.
+	var m match
+	var target string
+	var rule *compiledRule
+	var err error
+	snap.index.each(req.Host, func(block *compiledHostBlock, hostGroups []string) bool {
+		m = match{req: req, path: req.URL.Path, hostGroups: hostGroups, clock: snap.now}
+		target, rule, err = block.serve(&m)
+		return rule != nil || err != nil || !snap.cascade
+	})
+	if err != nil {
+		return err
+	}
 
-	block := findHostBlock(host)
-	if block != nil {
-		if to, ok := block.exactPaths[path]; ok {
-			return doRedirect(w, req, buildTarget(block.toHost, to, req), block.status)
+	if rule != nil {
+		if len(m.vary) > 0 {
+			w.Header().Add("Vary", strings.Join(m.vary, ", "))
 		}
-
-		if target, ok := matchPrefix(block, path, req); ok {
-			return doRedirect(w, req, target, block.status)
+		if target == "" && rule.action != actionRespond {
+			// to_host rendered to no usable host.
+			return next.ServeHTTP(w, req)
 		}
-
-		if target, ok := matchRegex(block, path, req); ok {
-			return doRedirect(w, req, target, block.status)
+		if m.cookie != nil {
+			http.SetCookie(w, m.cookie)
 		}
+		switch rule.action {
+		case actionRespond:
+			return rule.response.serve(w)
+		case actionRewrite:
+			if err := rewrite(req, target); err != nil {
+				return err
+			}
+			return next.ServeHTTP(w, req)
+		}
+		return doRedirect(w, req, target, rule.statusFor(&m))
 	}
 
 	return next.ServeHTTP(w, req)
 }
 
-func findHostBlock(host string) *compiledHostBlock {
-	var fallback *compiledHostBlock
-	for i := range compiled {
-		ch := &compiled[i]
-		switch {
-		case ch.exactHost != "" && host == ch.exactHost:
-			return ch
-		case ch.suffix != "" && strings.HasSuffix(host, ch.suffix):
-			return ch
-		case ch.matchAll:
-			if fallback == nil {
-				fallback = ch
+// serve checks the time window and conditions of the block and evaluates its
+// rules. After the window of a block with an expiry action, that action
+// handles every request.
+func (block *compiledHostBlock) serve(m *match) (string, *compiledRule, error) {
+	if w := block.window; w != nil && w.skips(m.now()) {
+		return "", nil, nil
+	}
+	ok, err := applies(block.conds, m.req)
+	if !ok || err != nil {
+		return "", nil, err
+	}
+	m.locales = block.locale
+	if w := block.window; w != nil && w.expired != nil && w.state(m.now()) == windowExpired {
+		return buildTarget(block, w.expired, w.expired.target(m).render(m), m), w.expired, nil
+	}
+	return block.evaluate(m)
+}
+
+// evaluate runs the rule types of a block in precedence order, once per rule
+// priority from highest to lowest, and returns the target together with the
+// rule that produced it. Within a type, candidates whose conditions don't
+// apply are skipped in favour of the next candidate.
+func (block *compiledHostBlock) evaluate(m *match) (string, *compiledRule, error) {
+	for _, level := range block.levels {
+		m.level = level
+		for _, kind := range block.order {
+			target, rule, err := kind.match(block, m)
+			if rule != nil || err != nil {
+				return target, rule, err
 			}
 		}
 	}
-	return fallback
+	return "", nil, nil
 }
 
-func matchPrefix(block *compiledHostBlock, path string, req *http.Request) (string, bool) {
-	if block.prefixBuckets == nil {
-		return "", false
+func matchExact(block *compiledHostBlock, m *match) (string, *compiledRule, error) {
+	for _, er := range block.exactPaths[m.path] {
+		ok, err := er.accepts(m)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			continue
+		}
+		rule := er.current(m)
+		return buildTarget(block, rule, rule.target(m).render(m), m), rule, nil
 	}
-	lst := block.prefixBuckets[bucketKey(path)]
-	if len(lst) == 0 {
-		return "", false
+	return "", nil, nil
+}
+
+func matchPath(block *compiledHostBlock, m *match) (string, *compiledRule, error) {
+	if block.paths == nil {
+		return "", nil, nil
 	}
-	for _, pr := range lst {
-		if strings.HasPrefix(path, pr.From) {
-			rest := path[len(pr.From):]
-			to := pr.To
-			if !strings.HasSuffix(to, "/") && rest != "" && !strings.HasPrefix(rest, "/") {
-				to += "/"
-			}
-			newPath := to + rest
-			return buildTarget(block.toHost, newPath, req), true
+	var target string
+	var rule *compiledRule
+	var err error
+	block.paths.lookup(m.path, func(pr *compiledPathRule, vals []string) bool {
+		var ok bool
+		if ok, err = pr.accepts(m); !ok || err != nil {
+			return err != nil
 		}
-	}
-	return "", false
+		m.pathVars = vals
+		rule = pr.current(m)
+		target = buildTarget(block, rule, rule.target(m).render(m), m)
+		return true
+	})
+	return target, rule, err
 }
 
-func matchRegex(block *compiledHostBlock, path string, req *http.Request) (string, bool) {
-	if len(block.regexRules) == 0 {
-		return "", false
+func matchPrefix(block *compiledHostBlock, m *match) (string, *compiledRule, error) {
+	if block.prefixes == nil {
+		return "", nil, nil
+	}
+	var pr *compiledPrefixRule
+	var err error
+	block.prefixes.longestFirst(m.path, func(c *compiledPrefixRule) bool {
+		var ok bool
+		if ok, err = c.accepts(m); ok && err == nil {
+			pr = c
+		}
+		return ok || err != nil
+	})
+	if pr == nil || err != nil {
+		return "", nil, err
 	}
-	for _, rr := range block.regexRules {
-		if rr.re.MatchString(path) {
-			out := rr.re.ReplaceAllString(path, rr.to)
-			return buildTarget(block.toHost, out, req), true
+
+	m.rest = m.path[len(pr.from):]
+	rule := pr.current(m)
+	to := rule.target(m)
+	newPath := to.render(m)
+	if !to.usesRest {
+		if !strings.HasSuffix(newPath, "/") && m.rest != "" && !strings.HasPrefix(m.rest, "/") {
+			newPath += "/"
 		}
+		newPath += escapePathValue(m.rest)
 	}
-	return "", false
+	return buildTarget(block, rule, newPath, m), rule, nil
+}
+
+func matchRegex(block *compiledHostBlock, m *match) (string, *compiledRule, error) {
+	if block.regex == nil {
+		return "", nil, nil
+	}
+	var target string
+	var rule *compiledRule
+	var err error
+	block.regex.each(m.path, func(i int) bool {
+		rr := &block.regex.rules[i]
+		if rr.priority != m.level {
+			return false
+		}
+		out, hit := rr.to.replaceAll(rr.re, m.path, m)
+		if !hit {
+			return false
+		}
+		var ok bool
+		if ok, err = rr.accepts(m); !ok || err != nil {
+			return err != nil
+		}
+		rule = rr.current(m)
+		if rule != &rr.compiledRule || rr.split != nil {
+			out, _ = rule.target(m).replaceAll(rr.re, m.path, m)
+		}
+		target = buildTarget(block, rule, out, m)
+		return true
+	})
+	return target, rule, err
 }
 
 func doRedirect(w http.ResponseWriter, req *http.Request, target string, status int) error {
@@ -172,19 +244,69 @@ func doRedirect(w http.ResponseWriter, req *http.Request, target string, status
 	return nil
 }
 
-func buildTarget(toHost string, candidate string, req *http.Request) string {
+// rewrite points the request at target, like Caddy's rewrite handler. Only
+// the path and query of the target are used.
+func rewrite(req *http.Request, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	req.URL.Path, req.URL.RawPath, req.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+	req.RequestURI = req.URL.RequestURI()
+	return nil
+}
+
+// buildTarget returns the final target of a redirect or rewrite rule, or ""
+// if its to_host doesn't render to a usable host.
+func buildTarget(block *compiledHostBlock, rule *compiledRule, candidate string, m *match) string {
+	if rule.action == actionRespond {
+		return ""
+	}
+	target, ok := joinHost(block, rule, candidate, m)
+	if !ok {
+		return ""
+	}
+	return rule.queryPolicy.apply(target, m.req.URL.RawQuery)
+}
+
+// joinHost attaches the rendered to_host to a relative candidate. It reports
+// false if placeholders or captures render the host empty or into something
+// that would change the meaning of the URL.
+func joinHost(block *compiledHostBlock, rule *compiledRule, candidate string, m *match) (string, bool) {
 	if isAbsoluteURL(candidate) {
-		return candidate
+		return candidate, true
 	}
 
-	if toHost == "" {
-		return candidate
+	hostTmpl := block.toHost
+	if rule.toHost != nil {
+		hostTmpl = rule.toHost
+	}
+	if hostTmpl.raw == "" {
+		return candidate, true
+	}
+	toHost := hostTmpl.render(m)
+	if !validHost(toHost) {
+		return "", false
 	}
 	p := candidate
 	if !strings.HasPrefix(p, "/") {
 		p = "/" + p
 	}
-	return schemeFromRequest(req) + "://" + toHost + p
+	return schemeFromRequest(m.req) + "://" + toHost + p, true
+}
+
+// validHost reports whether a rendered to_host can be used as the authority
+// of a URL: it must not be empty, have an empty label or contain a path,
+// query, fragment or userinfo delimiter, a backslash or whitespace.
+func validHost(host string) bool {
+	switch {
+	case host == "", host[0] == '.', strings.Contains(host, ".."):
+		return false
+	case strings.ContainsAny(host, "/?#@\\"), strings.IndexFunc(host, unicode.IsSpace) >= 0:
+		return false
+	}
+	return true
 }
 
 func isAbsoluteURL(s string) bool {
@@ -197,17 +319,3 @@ func schemeFromRequest(req *http.Request) string {
 	}
 	return "https"
 }
-
-func bucketKey(s string) string {
-	if len(s) < 2 || s[0] != '/' {
-		return ""
-	}
-	s = s[1:]
-	if i := strings.IndexByte(s, '/'); i >= 0 {
-		s = s[:i]
-	}
-	if len(s) > 8 {
-		s = s[:8]
-	}
-	return s
-}
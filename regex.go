@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"regexp/syntax"
+	"strings"
+)
+
+// regexLiterals are facts about a pattern that hold for every path it can
+// match. They are extracted from the parsed syntax tree at provision time and
+// let the hot path reject most regex rules with plain string checks.
+type regexLiterals struct {
+	prefix   string // path must start with it (pattern is anchored with ^)
+	suffix   string // path must end with it (pattern is anchored with $)
+	required string // longest other literal the path must contain
+}
+
+func analyzeRegex(pattern string) regexLiterals {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return regexLiterals{}
+	}
+
+	var lits regexLiterals
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+
+	if len(subs) > 0 && subs[0].Op == syntax.OpBeginText {
+		var b strings.Builder
+		for _, sub := range subs[1:] {
+			s, ok := literalOf(sub)
+			if !ok {
+				break
+			}
+			b.WriteString(s)
+		}
+		lits.prefix = b.String()
+	}
+
+	if n := len(subs); n > 1 && subs[n-1].Op == syntax.OpEndText {
+		if s, ok := literalOf(subs[n-2]); ok {
+			lits.suffix = s
+		}
+	}
+
+	for _, s := range requiredLiterals(re, nil) {
+		if len(s) > len(lits.required) && !strings.Contains(lits.prefix, s) && !strings.Contains(lits.suffix, s) {
+			lits.required = s
+		}
+	}
+	return lits
+}
+
+// literalOf reports the case-sensitive literal text of re, if it is one.
+func literalOf(re *syntax.Regexp) (string, bool) {
+	if re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(re.Rune), true
+}
+
+// requiredLiterals appends the literals that must occur in every match of re.
+// Alternations are skipped since neither branch is mandatory.
+func requiredLiterals(re *syntax.Regexp, out []string) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if s, ok := literalOf(re); ok {
+			out = append(out, s)
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			out = requiredLiterals(sub, out)
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		out = requiredLiterals(re.Sub[0], out)
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			out = requiredLiterals(re.Sub[0], out)
+		}
+	}
+	return out
+}
+
+func (l regexLiterals) admits(path string) bool {
+	return strings.HasPrefix(path, l.prefix) &&
+		strings.HasSuffix(path, l.suffix) &&
+		strings.Contains(path, l.required)
+}
+
+// regexIndex narrows the regex rules of a host block down to the ones whose
+// literals fit the path. Rules anchored on a literal prefix are found with a
+// radix walk; all others are checked individually. Candidates are always
+// returned in declaration order, so the first matching rule still wins.
+type regexIndex struct {
+	rules    []compiledRegexRule
+	anchored radixTree[[]int]
+	floating []int
+}
+
+func newRegexIndex(rules []compiledRegexRule) *regexIndex {
+	x := &regexIndex{rules: rules}
+	for i, rr := range rules {
+		if rr.lits.prefix == "" {
+			x.floating = append(x.floating, i)
+			continue
+		}
+		n := x.anchored.node(rr.lits.prefix)
+		n.value, n.set = append(n.value, i), true
+	}
+	return x
+}
+
+// each calls fn with the index of every rule that may match path, in
+// declaration order, until fn returns true.
+func (x *regexIndex) each(path string, fn func(i int) bool) {
+	var buf [8][]int
+	lists := buf[:0]
+	x.anchored.walk(path, func(idx []int) { lists = append(lists, idx) })
+	if len(x.floating) > 0 {
+		lists = append(lists, x.floating)
+	}
+
+	for {
+		next := -1
+		for l, idx := range lists {
+			if len(idx) > 0 && (next < 0 || idx[0] < lists[next][0]) {
+				next = l
+			}
+		}
+		if next < 0 {
+			return
+		}
+
+		i := lists[next][0]
+		lists[next] = lists[next][1:]
+		if x.rules[i].lits.admits(path) && fn(i) {
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// ruleAction is what a matching rule does with the request.
+type ruleAction int
+
+const (
+	actionRedirect ruleAction = iota
+	actionRewrite
+	actionRespond
+)
+
+// Action names that are not responses. They may also be set on a host block.
+const (
+	actionNameRedirect = "redirect"
+	actionNameRewrite  = "rewrite"
+)
+
+// responseActions maps the terminal actions to the status they answer with.
+var responseActions = map[string]int{
+	"gone":                          http.StatusGone,
+	"not_found":                     http.StatusNotFound,
+	"unavailable_for_legal_reasons": http.StatusUnavailableForLegalReasons,
+}
+
+// staticResponse is the answer of a terminal rule, prepared at provision
+// time.
+type staticResponse struct {
+	status      int
+	body        []byte
+	contentType string
+}
+
+// response compiles the terminal action of a rule.
+func (c *compiler) response(status int, opts RuleOptions) (*staticResponse, error) {
+	sr := &staticResponse{status: status, body: []byte(opts.Body), contentType: opts.ContentType}
+	if opts.BodyFile != "" {
+		if opts.Body != "" {
+			return nil, fmt.Errorf("action %s: body and body_file are mutually exclusive", opts.Action)
+		}
+		body, err := c.bodyFile(opts.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("action %s: %w", opts.Action, err)
+		}
+		sr.body = body
+	}
+	if sr.contentType == "" && len(sr.body) > 0 {
+		sr.contentType = http.DetectContentType(sr.body)
+	}
+	return sr, nil
+}
+
+// bodyFile reads a body file once per provisioning, however many rules use it.
+func (c *compiler) bodyFile(path string) ([]byte, error) {
+	abs := resolvePath(c.baseDir, path)
+	if body, ok := c.bodies[abs]; ok {
+		return body, nil
+	}
+
+	body, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	if c.bodies == nil {
+		c.bodies = make(map[string][]byte)
+	}
+	c.bodies[abs] = body
+	return body, nil
+}
+
+func (sr *staticResponse) serve(w http.ResponseWriter) error {
+	if len(sr.body) > 0 {
+		w.Header().Set("Content-Type", sr.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(sr.body)))
+	}
+	w.WriteHeader(sr.status)
+	_, err := w.Write(sr.body)
+	return err
+}
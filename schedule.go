@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"time"
+)
+
+// expiredPass is the expiry action that skips a rule or host block, as if it
+// had never been configured.
+const expiredPass = "pass"
+
+// timestampLayouts are the accepted forms of active_from and active_until.
+// Timestamps without a zone are UTC.
+var timestampLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func parseTimestamp(ts Timestamp) (time.Time, error) {
+	s := string(ts)
+	if len(s) > 10 && s[10] == ' ' {
+		s = s[:10] + "T" + s[11:] // TOML allows a space between date and time
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 timestamp or a date", string(ts))
+}
+
+// parseWindow parses the bounds of a Schedule and checks that they and the
+// expiry action fit together.
+func parseWindow(s Schedule) (from, until time.Time, err error) {
+	if s.ActiveFrom != "" {
+		if from, err = parseTimestamp(s.ActiveFrom); err != nil {
+			return from, until, fmt.Errorf("active_from: %w", err)
+		}
+	}
+	if s.ActiveUntil != "" {
+		if until, err = parseTimestamp(s.ActiveUntil); err != nil {
+			return from, until, fmt.Errorf("active_until: %w", err)
+		}
+	}
+	if !from.IsZero() && !until.IsZero() && !until.After(from) {
+		return from, until, fmt.Errorf("active_until must be after active_from")
+	}
+	if s.Expired != "" && s.Expired != expiredPass && until.IsZero() {
+		return from, until, fmt.Errorf("expired needs active_until")
+	}
+	return from, until, nil
+}
+
+// expiredTarget returns the target of an expiry action, if it is one.
+func (s Schedule) expiredTarget() string {
+	if _, respond := responseActions[s.Expired]; respond || s.Expired == expiredPass {
+		return ""
+	}
+	return s.Expired
+}
+
+type windowState int
+
+const (
+	windowActive windowState = iota
+	windowPending
+	windowExpired
+)
+
+// window limits a rule or host block to the time between from and until.
+// Either bound may be zero. It is checked on every request against the clock
+// of the Redirector, so a boundary takes effect without a reload.
+type window struct {
+	from, until time.Time
+	expired     *compiledRule // what happens after until; nil passes
+}
+
+func (w *window) state(now time.Time) windowState {
+	switch {
+	case !w.from.IsZero() && now.Before(w.from):
+		return windowPending
+	case !w.until.IsZero() && !now.Before(w.until):
+		return windowExpired
+	}
+	return windowActive
+}
+
+// skips reports whether a rule or host block is left out at now: before its
+// window, or after it without an expiry action.
+func (w *window) skips(now time.Time) bool {
+	switch w.state(now) {
+	case windowPending:
+		return true
+	case windowExpired:
+		return w.expired == nil
+	}
+	return false
+}
+
+// window compiles a Schedule. The expiry action is compiled like a rule in
+// the same scope: a response action name answers with that response, anything
+// else but pass is a target redirected (or rewritten) to with the status,
+// promotion and query policy of opts.
+func (c *compiler) window(key string, s Schedule, opts RuleOptions, scope tmplScope, defaults ruleDefaults) (*window, error) {
+	if s == (Schedule{}) {
+		return nil, nil
+	}
+	w := &window{}
+	var err error
+	if w.from, w.until, err = parseWindow(s); err != nil {
+		return nil, err
+	}
+
+	if s.Expired == "" || s.Expired == expiredPass {
+		return w, nil
+	}
+
+	exp := RuleOptions{Query: opts.Query}
+	to := s.expiredTarget()
+	switch {
+	case to == "":
+		exp.Action = s.Expired
+	case opts.Action == actionNameRewrite:
+		exp.Action = actionNameRewrite
+	default:
+		exp.Status, exp.PromoteAfter = opts.Status, opts.PromoteAfter
+	}
+	if w.expired, err = c.rule(key, to, exp, scope, defaults); err != nil {
+		return nil, fmt.Errorf("expired: %w", err)
+	}
+	return w, nil
+}
+
+// now returns the time the rules of a request are evaluated at, read once per
+// request.
+func (m *match) now() time.Time {
+	if m.at.IsZero() {
+		m.at = m.clock()
+	}
+	return m.at
+}
+
+// current returns the rule that handles a request cr accepted: cr itself, or
+// its expiry action once its window has passed.
+func (cr *compiledRule) current(m *match) *compiledRule {
+	if cr.window != nil && cr.window.expired != nil && cr.window.state(m.now()) == windowExpired {
+		return cr.window.expired
+	}
+	return cr
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// variantPlaceholder holds the name of the target picked by a split rule.
+const variantPlaceholder = "http.redirector.variant"
+
+// temporaryCodes maps the permanent redirect codes to the temporary ones a
+// split rule inheriting them uses instead. A permanent redirect is kept by
+// browsers and shared caches, which would pin them to one variant.
+var temporaryCodes = map[int]int{
+	http.StatusMovedPermanently:  http.StatusFound,
+	http.StatusPermanentRedirect: http.StatusTemporaryRedirect,
+}
+
+// stickyCookieAge is how long a client keeps its variant with cookie
+// stickiness, in seconds.
+const stickyCookieAge = 30 * 24 * 60 * 60
+
+type stickyKind int
+
+const (
+	stickyNone stickyKind = iota
+	stickyCookie
+	stickyHeader
+	stickyClientIP
+)
+
+// split spreads the requests of a rule over weighted targets.
+type split struct {
+	variants []variant
+	total    int
+	sticky   stickyKind
+	key      string // cookie or header name
+}
+
+type variant struct {
+	to     *template
+	weight int
+	name   string
+}
+
+func compileSplit(targets []WeightedTarget, sticky string, scope tmplScope) (*split, error) {
+	if err := checkTargets(targets); err != nil {
+		return nil, err
+	}
+	s := &split{}
+	for _, wt := range targets {
+		tmpl, err := compileTemplate(wt.To, scope)
+		if err != nil {
+			return nil, err
+		}
+		s.variants = append(s.variants, variant{to: tmpl, weight: wt.Weight, name: wt.name()})
+		s.total += wt.Weight
+	}
+
+	kind, key, _ := strings.Cut(strings.TrimSpace(sticky), " ")
+	key = strings.TrimSpace(key)
+	switch {
+	case kind == "":
+	case kind == "client_ip" && key == "":
+		s.sticky = stickyClientIP
+	case kind == "cookie" && key != "":
+		s.sticky, s.key = stickyCookie, key
+	case kind == "header" && key != "":
+		s.sticky, s.key = stickyHeader, http.CanonicalHeaderKey(key)
+	default:
+		return nil, fmt.Errorf("sticky must be cookie <name>, header <name> or client_ip, %q given", sticky)
+	}
+	return s, nil
+}
+
+// checkTargets is shared by the validator and the compiler.
+func checkTargets(targets []WeightedTarget) error {
+	seen := make(map[string]bool, len(targets))
+	for _, wt := range targets {
+		if wt.Weight <= 0 {
+			return fmt.Errorf("target %q: weight must be positive, %d given", wt.To, wt.Weight)
+		}
+		if seen[wt.name()] {
+			return fmt.Errorf("target %q: name %q is used by another target", wt.To, wt.name())
+		}
+		seen[wt.name()] = true
+	}
+	return nil
+}
+
+// checkSplitStatus rejects a permanent status set on a split rule.
+func checkSplitStatus(status int) error {
+	if _, ok := temporaryCodes[status]; ok {
+		return fmt.Errorf("targets need a temporary status (302, 303 or 307), %d given", status)
+	}
+	return nil
+}
+
+func (wt WeightedTarget) name() string {
+	if wt.Name == "" {
+		return wt.To
+	}
+	return wt.Name
+}
+
+// pick chooses the variant for a request. Cookie stickiness keeps the variant
+// named by the cookie and otherwise picks one by weight and sets the cookie;
+// header and client IP stickiness hash the value onto the weights. Requests
+// without a value to stick to get a random variant. Responses vary on the
+// cookie or header.
+func (s *split) pick(m *match) *variant {
+	switch s.sticky {
+	case stickyCookie:
+		m.addVary("Cookie")
+		if c, err := m.req.Cookie(s.key); err == nil {
+			for i := range s.variants {
+				if s.variants[i].name == c.Value {
+					return &s.variants[i]
+				}
+			}
+		}
+		v := s.at(rand.IntN(s.total))
+		m.cookie = &http.Cookie{
+			Name:     s.key,
+			Value:    v.name,
+			Path:     "/",
+			MaxAge:   stickyCookieAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+		return v
+	case stickyHeader:
+		m.addVary(s.key)
+		if h := m.req.Header.Get(s.key); h != "" {
+			return s.at(bucket(h, s.total))
+		}
+	case stickyClientIP:
+		if ip := clientIP(m.req); ip != "" {
+			return s.at(bucket(ip, s.total))
+		}
+	}
+	return s.at(rand.IntN(s.total))
+}
+
+// at returns the variant covering n in [0, total).
+func (s *split) at(n int) *variant {
+	for i := range s.variants {
+		if n < s.variants[i].weight {
+			return &s.variants[i]
+		}
+		n -= s.variants[i].weight
+	}
+	return &s.variants[len(s.variants)-1]
+}
+
+func bucket(key string, total int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(total))
+}
+
+// clientIP prefers the address Caddy determined, which honours trusted
+// proxies, over the connection's remote address.
+func clientIP(req *http.Request) string {
+	if ip, ok := caddyhttp.GetVar(req.Context(), caddyhttp.ClientIPVarKey).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// target returns the template a rule redirects to, picking a variant for
+// split rules and exposing its name as a placeholder.
+func (cr *compiledRule) target(m *match) *template {
+	if cr.split == nil {
+		return cr.to
+	}
+	v := cr.split.pick(m)
+	if repl := m.replacer(); repl != nil {
+		repl.Set(variantPlaceholder, v.name)
+	}
+	return v.to
+}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// template is a target or to_host string compiled at provision time. It is a
+// sequence of literal text and references like {tenant} that are bound to a
+// capture source when compiled, so expanding it never parses anything.
+// References that name neither a host capture, a path parameter nor the
+// locale are Caddy placeholders, looked up in the request's replacer.
+type template struct {
+	raw   string
+	parts []tmplPart
+	// usesRest is set when a function call references the prefix remainder,
+	// which is then not appended to the target.
+	usesRest bool
+	// addsLocale is set when the target starts with /{locale}.
+	addsLocale bool
+}
+
+type tmplSource int
+
+const (
+	srcLiteral     tmplSource = iota
+	srcHost                   // host regex submatch
+	srcPath                   // path template parameter
+	srcPlaceholder            // Caddy placeholder, key holds its name
+	srcCall                   // template function call
+	srcGroup                  // regex submatch of the path, in call arguments
+	srcRest                   // prefix remainder, in call arguments
+	srcLocale                 // locale of the request
+)
+
+type tmplPart struct {
+	lit  string
+	src  tmplSource
+	idx  int
+	key  string
+	call *tmplCall
+}
+
+// tmplScope lists the names a template may reference.
+type tmplScope struct {
+	hostRe   *regexp.Regexp
+	pathVars []string
+	pathRe   *regexp.Regexp // regex rules: the path pattern
+	prefix   bool           // prefix rules: $rest is the remainder
+	locale   bool           // host blocks with a locale config: {locale}
+}
+
+func compileTemplate(s string, scope tmplScope) (*template, error) {
+	t := &template{raw: s}
+	lit := 0
+	for i := 0; i < len(s); i++ {
+		// ${name} is a regex group reference, handled by re.ExpandString.
+		if s[i] != '{' || i > 0 && s[i-1] == '$' {
+			continue
+		}
+
+		if call, n, ok, err := parseCall(s[i:], scope); ok || err != nil {
+			if err != nil {
+				return nil, fmt.Errorf("target %q: %w", s, err)
+			}
+			t.appendLit(s[lit:i])
+			t.parts = append(t.parts, tmplPart{src: srcCall, call: call})
+			t.usesRest = t.usesRest || call.usesRest()
+			i += n - 1
+			lit = i + 1
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			break
+		}
+		part, ok := scope.resolve(s[i+1 : i+end])
+		if !ok {
+			continue
+		}
+
+		t.appendLit(s[lit:i])
+		t.parts = append(t.parts, part)
+		i += end
+		lit = i + 1
+	}
+	t.appendLit(s[lit:])
+	t.addsLocale = len(t.parts) > 1 && t.parts[0].src == srcLiteral && t.parts[0].lit == "/" && t.parts[1].src == srcLocale
+	return t, nil
+}
+
+func (t *template) appendLit(s string) {
+	if s != "" {
+		t.parts = append(t.parts, tmplPart{lit: s})
+	}
+}
+
+// static reports whether the template expands to its raw text.
+func (t *template) static() bool {
+	return len(t.parts) == 0 || len(t.parts) == 1 && t.parts[0].src == srcLiteral
+}
+
+// resolve binds a {name} reference. Path template parameters shadow host
+// captures of the same name.
+func (sc tmplScope) resolve(name string) (tmplPart, bool) {
+	if i := slices.Index(sc.pathVars, name); i >= 0 {
+		return tmplPart{src: srcPath, idx: i}, true
+	}
+	if i, ok := sc.hostGroup(name); ok {
+		return tmplPart{src: srcHost, idx: i}, true
+	}
+	if sc.locale && name == localeRef {
+		return tmplPart{src: srcLocale}, true
+	}
+	if isPlaceholderName(name) {
+		return tmplPart{src: srcPlaceholder, lit: "{" + name + "}", key: placeholderKey(name)}, true
+	}
+	return tmplPart{}, false
+}
+
+// hostGroup resolves {name} and {host.name} to a named host capture and
+// {host.N} to a numbered one.
+func (sc tmplScope) hostGroup(name string) (int, bool) {
+	if sc.hostRe == nil {
+		return 0, false
+	}
+	name, qualified := strings.CutPrefix(name, "host.")
+	if n, err := strconv.Atoi(name); err == nil {
+		return n, qualified && n >= 0 && n <= sc.hostRe.NumSubexp()
+	}
+	if name == "" {
+		return 0, false
+	}
+	i := sc.hostRe.SubexpIndex(name)
+	return i, i >= 0
+}
+
+// pathEscaper re-escapes the characters of a decoded request path that would
+// end the path of a target or start an escape sequence, so /old/a%3Fb isn't
+// sent to /new/a?b.
+var pathEscaper = strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23")
+
+func escapePathValue(s string) string {
+	if !strings.ContainsAny(s, "%?#") {
+		return s
+	}
+	return pathEscaper.Replace(s)
+}
+
+// pathMatch is a regex match of the request path. esc and escLoc are src and
+// loc with the path re-escaped, for $1 and ${name} in literal text; function
+// calls get the decoded groups.
+type pathMatch struct {
+	re          *regexp.Regexp
+	src, esc    string
+	loc, escLoc []int
+}
+
+func newPathMatch(re *regexp.Regexp, src string, loc []int) pathMatch {
+	pm := pathMatch{re: re, src: src, esc: src, loc: loc, escLoc: loc}
+	if !strings.ContainsAny(src, "%?#") {
+		return pm
+	}
+	// Map every offset of src to its offset in the escaped path.
+	offsets := make([]int, len(src)+1)
+	var b strings.Builder
+	for i := range len(src) {
+		offsets[i] = b.Len()
+		b.WriteString(escapePathValue(src[i : i+1]))
+	}
+	offsets[len(src)] = b.Len()
+	pm.esc = b.String()
+	pm.escLoc = make([]int, len(loc))
+	for i, o := range loc {
+		pm.escLoc[i] = o
+		if o >= 0 {
+			pm.escLoc[i] = offsets[o]
+		}
+	}
+	return pm
+}
+
+// expand renders the template. Literal parts of regex targets go through
+// re.ExpandString so $1 and ${name} refer to the path match. Values taken
+// from the request path are re-escaped.
+func (t *template) expand(dst []byte, m *match, pm *pathMatch) []byte {
+	if t.static() && pm == nil {
+		return append(dst, t.raw...)
+	}
+	for _, p := range t.parts {
+		switch {
+		case p.src == srcHost:
+			if p.idx < len(m.hostGroups) {
+				dst = append(dst, m.hostGroups[p.idx]...)
+			}
+		case p.src == srcPath:
+			if p.idx < len(m.pathVars) {
+				dst = append(dst, escapePathValue(m.pathVars[p.idx])...)
+			}
+		case p.src == srcLocale:
+			dst = append(dst, m.locale()...)
+		case p.src == srcCall:
+			if pm != nil {
+				dst = append(dst, p.call.eval(m, pm.src, pm.loc)...)
+			} else {
+				dst = append(dst, p.call.eval(m, "", nil)...)
+			}
+		case p.src == srcPlaceholder:
+			if v, ok := m.placeholder(p.key); ok {
+				if name, isHeader := strings.CutPrefix(p.key, "http.request.header."); isHeader {
+					m.addVary(http.CanonicalHeaderKey(name))
+				}
+				dst = append(dst, v...)
+			} else {
+				dst = append(dst, p.lit...)
+			}
+		case pm != nil:
+			dst = pm.re.ExpandString(dst, p.lit, pm.esc, pm.escLoc)
+		default:
+			dst = append(dst, p.lit...)
+		}
+	}
+	return dst
+}
+
+// render expands a template that does not depend on a path match.
+func (t *template) render(m *match) string {
+	if t.static() {
+		return t.raw
+	}
+	return string(t.expand(nil, m, nil))
+}
+
+// replaceAll mirrors regexp.ReplaceAllString with t as the replacement. src
+// is the decoded request path, which is re-escaped in the result.
+func (t *template) replaceAll(re *regexp.Regexp, src string, m *match) (string, bool) {
+	matches := re.FindAllStringSubmatchIndex(src, -1)
+	if matches == nil {
+		return "", false
+	}
+
+	var out []byte
+	last := 0
+	for _, loc := range matches {
+		out = append(out, escapePathValue(src[last:loc[0]])...)
+		pm := newPathMatch(re, src, loc)
+		out = t.expand(out, m, &pm)
+		last = loc[1]
+	}
+	out = append(out, escapePathValue(src[last:])...)
+	return string(out), true
+}
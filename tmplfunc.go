@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tmplFunc is a function usable in targets as {name args...}. The last
+// argument is the value being transformed, like in a pipeline.
+type tmplFunc struct {
+	arity int
+	fn    func(args []string) string
+}
+
+var tmplFuncs = map[string]tmplFunc{
+	"lower":      {1, func(a []string) string { return strings.ToLower(a[0]) }},
+	"upper":      {1, func(a []string) string { return strings.ToUpper(a[0]) }},
+	"kebab":      {1, func(a []string) string { return slugify(a[0], '-') }},
+	"snake":      {1, func(a []string) string { return slugify(a[0], '_') }},
+	"urlencode":  {1, func(a []string) string { return url.QueryEscape(a[0]) }},
+	"pathescape": {1, func(a []string) string { return url.PathEscape(a[0]) }},
+	"trimPrefix": {2, func(a []string) string { return strings.TrimPrefix(a[1], a[0]) }},
+	"trimSuffix": {2, func(a []string) string { return strings.TrimSuffix(a[1], a[0]) }},
+	"replace":    {3, func(a []string) string { return strings.ReplaceAll(a[2], a[0], a[1]) }},
+	"default": {2, func(a []string) string {
+		if a[0] != "" {
+			return a[0]
+		}
+		return a[1]
+	}},
+}
+
+// tmplCall is a compiled function call. Its arguments are literals or
+// references bound when the template is compiled.
+type tmplCall struct {
+	fn   tmplFunc
+	args []tmplPart
+}
+
+// parseCall parses a function call such as {trimSuffix ".html" $1} at the
+// start of s and returns it with the number of bytes it spans. ok is false if
+// s doesn't start with a call, so the braces are handled like any other.
+func parseCall(s string, scope tmplScope) (call *tmplCall, n int, ok bool, err error) {
+	name, rest, found := strings.Cut(s[1:], " ")
+	if !found || !isIdent(name) {
+		return nil, 0, false, nil
+	}
+	fn, known := tmplFuncs[name]
+	if !known {
+		// Only something shaped like a call is reported, so literal text in
+		// braces keeps working.
+		if args, _, _ := strings.Cut(rest, "}"); strings.ContainsAny(args, "$\"") {
+			return nil, 0, false, fmt.Errorf("unknown function %q", name)
+		}
+		return nil, 0, false, nil
+	}
+
+	call = &tmplCall{fn: fn}
+	i := len(s) - len(rest)
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, false, fmt.Errorf("%s: missing }", name)
+		}
+		if s[i] == '}' {
+			break
+		}
+
+		var arg tmplPart
+		var width int
+		switch s[i] {
+		case '"':
+			lit, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return nil, 0, false, fmt.Errorf("%s: bad string argument: %w", name, err)
+			}
+			arg.lit, _ = strconv.Unquote(lit)
+			width = len(lit)
+		case '$':
+			ref, w := refName(s[i+1:])
+			if ref == "" {
+				return nil, 0, false, fmt.Errorf("%s: bad reference at %q", name, s[i:])
+			}
+			if arg, ok = scope.resolveRef(ref); !ok {
+				return nil, 0, false, fmt.Errorf("%s: unknown reference $%s", name, ref)
+			}
+			width = 1 + w
+		default:
+			return nil, 0, false, fmt.Errorf("%s: arguments must be quoted strings or $references", name)
+		}
+		call.args = append(call.args, arg)
+		i += width
+	}
+
+	if len(call.args) != fn.arity {
+		return nil, 0, false, fmt.Errorf("%s takes %d argument(s), %d given", name, fn.arity, len(call.args))
+	}
+	return call, i + 1, true, nil
+}
+
+// refName reads the name of a $1, $name or ${name} reference and returns it
+// with the number of bytes it spans.
+func refName(s string) (string, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	n := 0
+	for n < len(s) && (isIdentByte(s[n]) || s[n] == '.') {
+		n++
+	}
+	return s[:n], n
+}
+
+// resolveRef binds a $reference in a call argument: a regex group of the path
+// for regex rules, a parameter for path rules, rest for prefix rules, or a
+// host capture.
+func (sc tmplScope) resolveRef(name string) (tmplPart, bool) {
+	if sc.pathRe != nil {
+		if i, err := strconv.Atoi(name); err == nil {
+			return tmplPart{src: srcGroup, idx: i}, i >= 0 && i <= sc.pathRe.NumSubexp()
+		}
+		if i := sc.pathRe.SubexpIndex(name); i >= 0 {
+			return tmplPart{src: srcGroup, idx: i}, true
+		}
+	}
+	if sc.prefix && name == "rest" {
+		return tmplPart{src: srcRest}, true
+	}
+	if part, ok := sc.resolve(name); ok && part.src != srcPlaceholder {
+		return part, true
+	}
+	return tmplPart{}, false
+}
+
+func (c *tmplCall) usesRest() bool {
+	for _, a := range c.args {
+		if a.src == srcRest {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *tmplCall) eval(m *match, src string, loc []int) string {
+	var buf [3]string
+	args := buf[:0]
+	for _, a := range c.args {
+		var v string
+		switch a.src {
+		case srcLiteral:
+			v = a.lit
+		case srcGroup:
+			if 2*a.idx+1 < len(loc) && loc[2*a.idx] >= 0 {
+				v = src[loc[2*a.idx]:loc[2*a.idx+1]]
+			}
+		case srcRest:
+			v = m.rest
+		case srcHost:
+			if a.idx < len(m.hostGroups) {
+				v = m.hostGroups[a.idx]
+			}
+		case srcPath:
+			if a.idx < len(m.pathVars) {
+				v = m.pathVars[a.idx]
+			}
+		case srcLocale:
+			v = m.locale()
+		}
+		args = append(args, v)
+	}
+	return c.fn.fn(args)
+}
+
+// slugify lowercases s and joins its words with sep. Words are separated by
+// anything that is not a letter or digit and by lower-to-upper case changes,
+// so My_Old_Page and MyOldPage both become my-old-page.
+func slugify(s string, sep rune) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	pending, prevLower := false, false
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			pending, prevLower = b.Len() > 0, false
+			continue
+		}
+		if unicode.IsUpper(r) && prevLower {
+			pending = true
+		}
+		if pending {
+			b.WriteRune(sep)
+			pending = false
+		}
+		prevLower = unicode.IsLower(r) || unicode.IsDigit(r)
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,1944 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	redir "github.com/Bl4cky99/caddy-redirector"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redirector", func() {
+	var s *Suite
+
+	BeforeEach(func() {
+		s = NewSuite()
+	})
+
+	Describe("Exact rules", func() {
+		It("redirects with JSON config and ToHost", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_exact.json")
+			resp := s.RunOnce(r, &RequestSpec{Host: "exact.example", Path: "/old"}, nil)
+			AssertRedirect(resp, 301, "https://success.example/new")
+		})
+
+		It("redirects without ToHost using same host", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "samehost.example", Exact: map[string]string{"/from": "/to"}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "samehost.example", Path: "/from"}, nil)
+			AssertRedirect(resp, 308, "/to")
+		})
+
+		It("ignores ToHost when target is an absolute URL", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/absolute_exact.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "abs.example", Path: "/foo"}, nil)
+			AssertRedirect(resp, 308, "https://absolute.example/foo")
+		})
+	})
+
+	Describe("Prefix rules", func() {
+		It("matches the longest prefix from YAML config", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_prefix.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "prefix.example", Path: "/a/long/thing"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/b/long/thing")
+		})
+
+		It("passes to next handler on miss", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_prefix.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "prefix.example", Path: "/nope"}, NextOK{})
+			AssertPassedThrough(resp, 204)
+		})
+
+		It("adds slash between To and remaining path when To has no trailing slash", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "slash.example", ToHost: "success.example", Prefix: []redir.PrefixRule{{From: "/p/", To: "/to"}}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "slash.example", Path: "/p/x"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/to/x")
+		})
+
+		It("uses stable order for equal-length prefixes", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{
+					Pattern: "eq.example",
+					ToHost:  "success.example",
+					Prefix:  []redir.PrefixRule{{From: "/aa/", To: "/X/"}, {From: "/ab/", To: "/Y/"}},
+				},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "eq.example", Path: "/ab/thing"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/Y/thing")
+		})
+		It("matches a root prefix against any path", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "root.example", Prefix: []redir.PrefixRule{{From: "/", To: "/new/"}}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "root.example", Path: "/some/page"}, nil)
+			AssertRedirect(resp, 308, "/new/some/page")
+		})
+
+		It("matches prefixes that end inside a path segment", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "partial.example", Prefix: []redir.PrefixRule{{From: "/bl", To: "/x"}}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "partial.example", Path: "/blog"}, nil)
+			AssertRedirect(resp, 308, "/x/og")
+		})
+
+		It("distinguishes prefixes whose first segment is longer than eight bytes", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{
+					Pattern: "long.example",
+					Prefix: []redir.PrefixRule{
+						{From: "/documentation/", To: "/docs/"},
+						{From: "/documents/", To: "/files/"},
+					},
+				},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "long.example", Path: "/documents/a.pdf"}, nil)
+			AssertRedirect(resp, 308, "/files/a.pdf")
+		})
+
+		It("keeps the first declared rule for duplicate prefixes", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{
+					Pattern: "dup.example",
+					Prefix:  []redir.PrefixRule{{From: "/a/", To: "/first/"}, {From: "/a/", To: "/second/"}},
+				},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "dup.example", Path: "/a/b"}, nil)
+			AssertRedirect(resp, 308, "/first/b")
+		})
+
+		It("picks the longest prefix among 100k rules", func() {
+			hb := redir.HostBlock{Pattern: "many.example"}
+			for i := 0; i < 100000; i++ {
+				n := strconv.Itoa(i)
+				hb.Prefix = append(hb.Prefix, redir.PrefixRule{From: "/c/" + n + "/", To: "/n/" + n + "/"})
+			}
+			hb.Prefix = append(hb.Prefix, redir.PrefixRule{From: "/c/4242/deep/", To: "/deep/"})
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{hb})
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "many.example", Path: "/c/4242/x"}, nil), 308, "/n/4242/x")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "many.example", Path: "/c/4242/deep/y"}, nil), 308, "/deep/y")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "many.example", Path: "/c/100000/"}, NextOK{}), 204)
+		})
+	})
+
+	Describe("Regex rules", func() {
+		It("captures groups from TOML config", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_regex.toml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "regex.example", Path: "/u/12345"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/users/12345")
+		})
+
+		It("ignores ToHost when target is an absolute URL", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_regex.toml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "regex.example", Path: "/abs/any/path"}, nil)
+			AssertRedirect(resp, 308, "https://absolute.example/any/path")
+		})
+
+		It("passes to next handler on miss", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_regex.toml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "regex.example", Path: "/u/abc"}, NextOK{})
+			AssertPassedThrough(resp, 204)
+		})
+
+		It("normalizes target with missing leading slash", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/regex_no_slash.json")
+			resp := s.RunOnce(r, &RequestSpec{Host: "noslash.example", Path: "/u/42"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/users/42")
+		})
+		It("keeps declaration order between anchored and unanchored rules", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{
+					Pattern: "order.example",
+					Regex: []redir.RegexRule{
+						{Pattern: "^/docs/v1/(.*)$", To: "/v1/$1"},
+						{Pattern: "/(.*)\\.php$", To: "/php/$1"},
+						{Pattern: "^/docs/(.*)$", To: "/docs-new/$1"},
+					},
+				},
+			})
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "order.example", Path: "/docs/v1/a.php"}, nil), 308, "/v1/a.php")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "order.example", Path: "/docs/v2/a.php"}, nil), 308, "/php/docs/v2/a")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "order.example", Path: "/docs/v2/a"}, nil), 308, "/docs-new/v2/a")
+		})
+
+		It("matches case-insensitive and partially anchored patterns", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{
+					Pattern: "fold.example",
+					Regex: []redir.RegexRule{
+						{Pattern: "(?i)^/OLD/(.*)$", To: "/new/$1"},
+						{Pattern: "^/(a|b)/x$", To: "/ab"},
+						{Pattern: "_", To: "-"},
+					},
+				},
+			})
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fold.example", Path: "/old/page"}, nil), 308, "/new/page")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fold.example", Path: "/b/x"}, nil), 308, "/ab")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fold.example", Path: "/a_b_c"}, nil), 308, "/a-b-c")
+		})
+
+		It("selects the right rule among 1k rules with literal prefixes", func() {
+			hb := redir.HostBlock{Pattern: "many.example"}
+			for i := 0; i < 1000; i++ {
+				n := strconv.Itoa(i)
+				hb.Regex = append(hb.Regex, redir.RegexRule{Pattern: "^/s" + n + "/([0-9]+)\\.html$", To: "/sec/" + n + "/$1"})
+			}
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{hb})
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "many.example", Path: "/s10/7.html"}, nil), 308, "/sec/10/7")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "many.example", Path: "/s999/42.html"}, nil), 308, "/sec/999/42")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "many.example", Path: "/s1/x.html"}, NextOK{}), 204)
+		})
+	})
+
+	Describe("Path template rules", func() {
+		It("fills named parameters into the target", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/path_rules.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/blog/2024/05/hello-world"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/articles/hello-world?y=2024")
+		})
+
+		It("prefers static segments over parameters", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/path_rules.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/blog/2024/05/latest"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/latest/2024/05")
+		})
+
+		It("checks parameter types and falls back to prefix rules", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/path_rules.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/blog/twenty/05/x"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/legacy/twenty/05/x")
+		})
+
+		It("matches parameters surrounded by literal text", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/path_rules.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/blog/post-42.html"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/p/42")
+		})
+
+		It("captures the remaining path with a trailing catch-all", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/path_rules.yaml")
+
+			resp1 := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/docs/guide/install"}, nil)
+			AssertRedirect(resp1, 308, "https://success.example/documentation/guide/install")
+
+			resp2 := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/docs"}, NextOK{})
+			AssertPassedThrough(resp2, 204)
+		})
+
+		It("ranks below exact rules", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/path_rules.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "path.example", Path: "/blog/2024/01/pinned"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/pinned")
+		})
+
+		It("fails provision on an invalid template", func() {
+			for _, pat := range []string{"blog/{slug}", "/a/{x:float}", "/{rest...}/x", "/{a}/{a}", "/{a}{b}"} {
+				r := &redir.Redirector{Hosts: []redir.HostBlock{
+					{Pattern: "bad.example", Path: []redir.PathRule{{Pattern: pat, To: "/x"}}},
+				}}
+				Expect(r.Provision(caddy.Context{})).To(HaveOccurred(), pat)
+			}
+		})
+	})
+
+	Describe("Query-aware rules", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/query_rules.toml")
+		})
+
+		It("matches exact rules on the query regardless of parameter order", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/index.php?id=42"}, nil), 308, "https://success.example/articles/42")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/view.asp?item=9&cat=3"}, nil), 308, "https://success.example/shop/3/9")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/tags.php?t=b&t=a"}, nil), 308, "https://success.example/tags/a+b")
+		})
+
+		It("compares decoded values", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "q.example", Exact: map[string]string{"/find?q=a+b": "/found"}},
+			})
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "q.example", Path: "/find?q=a%20b"}, nil), 308, "/found")
+		})
+
+		It("falls back to the query-less rule on other queries", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/index.php?id=7"}, nil), 308, "https://success.example/")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/index.php?id=42&x=1"}, nil), 308, "https://success.example/")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/view.asp?cat=3"}, NextOK{}), 204)
+		})
+
+		It("ignores extra parameters when asked to", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/search.php?page=2&q=go"}, nil), 308, "https://success.example/search/go")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/search.php?page=2"}, NextOK{}), 204)
+		})
+
+		It("matches prefix rules on the query", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/forum/topic/1?board=news"}, nil), 308, "https://success.example/news/topic/1")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "legacy.example", Path: "/forum/topic/1"}, nil), 308, "https://success.example/community/topic/1")
+		})
+	})
+
+	Describe("Query policy", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/query_policy.yaml")
+		})
+
+		It("drops the request query by default", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "q.example", Exact: map[string]string{"/old": "/new?x=1"}},
+			})
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "q.example", Path: "/old?a=1"}, nil), 308, "/new?x=1")
+		})
+
+		It("preserves the request query after the target's own parameters", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/old?a=1&b=%20"}, nil), 308, "/new?a=1&b=%20")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/own?x=2"}, nil), 308, "/new?x=1&x=2")
+		})
+
+		It("removes and renames request parameters", func() {
+			resp := s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/blog/a?utm_source=x&p=2&fbclid=y&utm_medium=z"}, nil)
+			AssertRedirect(resp, 308, "/news/a?page=2")
+		})
+
+		It("keeps query and fragment of absolute targets", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/abs?a=1"}, nil), 308, "https://other.example/p?k=v&a=1#top")
+		})
+
+		It("applies to regex targets", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/r/7?a=1"}, nil), 308, "/items/7?from=r&a=1")
+		})
+
+		It("lets target parameters win when merging", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/merge?a=1&b=2&utm_source=x"}, nil), 308, "/new?a=t&b=2&utm_source=x")
+		})
+
+		It("replaces the host policy with the rule policy", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "qp.example", Path: "/drop?a=1&utm_source=x"}, nil), 308, "/new?utm_source=redirector")
+		})
+
+		It("falls back to the global policy", func() {
+			r := &redir.Redirector{
+				Query: &redir.QueryPolicy{Mode: "preserve", Add: map[string]string{"ref": "old site"}},
+				Hosts: []redir.HostBlock{{Pattern: "g.example", Exact: map[string]string{"/a": "/b?ref=x"}}},
+			}
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "g.example", Path: "/a?q=1"}, nil), 308, "/b?q=1&ref=old+site")
+		})
+
+		It("fails provision on an unknown mode", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "bad.example", Query: &redir.QueryPolicy{Mode: "keep"}, Exact: map[string]string{"/a": "/b"}},
+			}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring(`unknown mode "keep"`)))
+		})
+	})
+
+	Describe("Response actions", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/actions.yaml")
+		})
+
+		It("answers with the status and body of the action", func() {
+			resp := s.RunOnce(r, &RequestSpec{Host: "gone.example", Path: "/retired"}, nil)
+			Expect(resp.Status()).To(Equal(410))
+			Expect(resp.Body()).To(Equal("This page has been removed."))
+			Expect(resp.Header("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			Expect(resp.Location()).To(BeEmpty())
+
+			resp = s.RunOnce(r, &RequestSpec{Host: "gone.example", Path: "/private"}, nil)
+			Expect(resp.Status()).To(Equal(451))
+			Expect(resp.Header("Content-Type")).To(Equal("application/json"))
+		})
+
+		It("follows the same precedence as redirects", func() {
+			resp := s.RunOnce(r, &RequestSpec{Host: "gone.example", Path: "/old-shop/item"}, nil)
+			Expect(resp.Status()).To(Equal(404))
+			Expect(resp.Body()).To(BeEmpty())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "gone.example", Path: "/old/blog"}, nil), 308, "https://success.example/new/blog")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "gone.example", Path: "/still-here"}, nil), 308, "https://success.example/moved")
+			Expect(s.RunOnce(r, &RequestSpec{Host: "gone.example", Path: "/archive/2019/x"}, nil).Status()).To(Equal(410))
+		})
+
+		It("serves the body from a file", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{{Pattern: "file.example", ExactRules: []redir.ExactRule{
+				{From: "/a", RuleOptions: redir.RuleOptions{Action: "gone", BodyFile: ConfigPath("configs/gone.html")}},
+				{From: "/b", RuleOptions: redir.RuleOptions{Action: "gone", BodyFile: ConfigPath("configs/gone.html")}},
+			}}})
+
+			resp := s.RunOnce(r, &RequestSpec{Host: "file.example", Path: "/b"}, nil)
+			Expect(resp.Status()).To(Equal(410))
+			Expect(resp.Body()).To(ContainSubstring("no longer available"))
+			Expect(resp.Header("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		})
+
+		It("fails provision on invalid action settings", func() {
+			for _, opts := range []redir.RuleOptions{
+				{Action: "teapot"},
+				{Action: "gone", Status: 301},
+				{Action: "gone", Body: "x", BodyFile: ConfigPath("configs/gone.html")},
+				{Action: "gone", BodyFile: ConfigPath("configs/missing.html")},
+				{Body: "x"},
+			} {
+				r := &redir.Redirector{Hosts: []redir.HostBlock{
+					{Pattern: "bad.example", ExactRules: []redir.ExactRule{{From: "/a", To: "/b", RuleOptions: opts}}},
+				}}
+				Expect(r.Provision(s.Context())).To(HaveOccurred(), "%+v", opts)
+			}
+		})
+	})
+
+	Describe("Rewrite action", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/rewrite.yaml")
+		})
+
+		It("rewrites the request and calls the next handler", func() {
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/old"}, NextURI{}), "/new")
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/docs/a%20b"}, NextURI{}), "/v2/docs/a%20b")
+		})
+
+		It("keeps encoded ? and # in the path", func() {
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/docs/a%3Fb%23c"}, NextURI{}), "/v2/docs/a%3Fb%23c")
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/docs/a%3Fb?x=1"}, NextURI{}), "/v2/docs/a%3Fb?x=1")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/go/100%25%3F"}, NextURI{}), 302, "/landing/100%25%3F")
+		})
+
+		It("keeps the request query unless the target has one or a policy is set", func() {
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "mixed.example", Path: "/api?page=2"}, NextURI{}), "/internal/api?page=2")
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "mixed.example", Path: "/search?page=2"}, NextURI{}), "/find?q=all")
+
+			r := &redir.Redirector{Query: &redir.QueryPolicy{Mode: "drop"}, Hosts: []redir.HostBlock{{
+				Pattern: "drop.example", Action: "rewrite", Exact: map[string]string{"/a": "/b"},
+			}}}
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "drop.example", Path: "/a?page=2"}, NextURI{}), "/b")
+		})
+
+		It("applies the query of the target and the query policy", func() {
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/u/7?ref=x"}, NextURI{}), "/users?id=7&ref=x")
+		})
+
+		It("lets rules override the host action", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/go/x"}, NextURI{}), 302, "/landing/x")
+		})
+
+		It("uses only path and query of the target", func() {
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "mixed.example", Path: "/api"}, NextURI{}), "/internal/api")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "mixed.example", Path: "/home"}, NextURI{}), 308, "https://backend.internal/")
+		})
+
+		It("leaves unmatched requests untouched", func() {
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "rw.example", Path: "/other?a=1"}, NextURI{}), "/other?a=1")
+		})
+
+		It("fails provision on a status for rewrites and unknown host actions", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{{Pattern: "bad.example", ExactRules: []redir.ExactRule{
+				{From: "/a", To: "/b", RuleOptions: redir.RuleOptions{Action: "rewrite", Status: 301}},
+			}}}}
+			Expect(r.Provision(s.Context())).To(HaveOccurred())
+
+			r = &redir.Redirector{Hosts: []redir.HostBlock{{Pattern: "bad.example", Action: "gone"}}}
+			Expect(r.Provision(s.Context())).To(HaveOccurred())
+		})
+	})
+
+	Describe("Caddy placeholders", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/placeholders.yaml")
+		})
+
+		It("expands placeholders in to_host and targets", func() {
+			req := &RequestSpec{Host: "ph.example", Path: "/search?q=go", Header: http.Header{"X-Tenant": {"acme"}}}
+			AssertRedirect(s.RunOnce(r, req, nil), 308, "https://acme.app.example/find?q=go")
+
+			req = &RequestSpec{Host: "ph.example", Path: "/locale", Header: http.Header{"X-Tenant": {"acme"}}, Vars: map[string]any{"locale": "de"}}
+			AssertRedirect(s.RunOnce(r, req, nil), 308, "https://acme.app.example/de/home")
+		})
+
+		It("does not expand regex references inside placeholder values", func() {
+			req := &RequestSpec{Host: "ph.example", Path: "/r/abc", Header: http.Header{"X-Tenant": {"t"}, "X-Suffix": {"$1"}}}
+			AssertRedirect(s.RunOnce(r, req, nil), 308, "https://t.app.example/abc/$1")
+		})
+
+		It("keeps unknown placeholders as they are", func() {
+			req := &RequestSpec{Host: "ph.example", Path: "/unknown", Header: http.Header{"X-Tenant": {"t"}}}
+			AssertRedirect(s.RunOnce(r, req, nil), 308, "https://t.app.example/a/{nope.nothing}")
+		})
+
+		It("expands environment placeholders", func() {
+			GinkgoT().Setenv("REDIRECTOR_TEST_HOST", "env.target")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "env.example", Path: "/env"}, nil), 308, "https://env.target/x")
+		})
+
+		It("adds the headers used by placeholders to Vary", func() {
+			resp := s.RunOnce(r, &RequestSpec{Host: "ph.example", Path: "/search?q=go", Header: http.Header{"X-Tenant": {"acme"}}}, nil)
+			AssertRedirect(resp, 308, "https://acme.app.example/find?q=go")
+			Expect(resp.Header("Vary")).To(Equal("X-Tenant"))
+
+			resp = s.RunOnce(r, &RequestSpec{Host: "ph.example", Path: "/r/abc", Header: http.Header{"X-Tenant": {"t"}, "X-Suffix": {"s"}}}, nil)
+			AssertRedirect(resp, 308, "https://t.app.example/abc/s")
+			Expect(resp.Header("Vary")).To(Equal("X-Suffix, X-Tenant"))
+
+			resp = s.RunOnce(r, &RequestSpec{Host: "env.example", Path: "/env"}, nil)
+			Expect(resp.Header("Vary")).To(BeEmpty())
+		})
+
+		It("passes to the next handler if to_host renders to an unusable host", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "raw.example", Path: "/go/a", Header: http.Header{"X-Target": {"ok.example:8443"}}}, NextOK{}), 308, "https://ok.example:8443/landing/a")
+
+			for _, host := range []string{"", "evil.example/x", "evil.example?", "evil.example#", "user@evil.example", `evil.example\x`, "evil .example", "evil.example\t", ".app.example", "a..example"} {
+				req := &RequestSpec{Host: "raw.example", Path: "/go/a", Header: http.Header{"X-Target": {host}}}
+				resp := s.RunOnce(r, req, NextOK{})
+				AssertPassedThrough(resp, 204)
+				Expect(resp.Location()).To(BeEmpty(), "host %q", host)
+				Expect(resp.Header("Vary")).To(Equal("X-Target"))
+			}
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "ph.example", Path: "/search"}, NextOK{}), 204)
+		})
+	})
+
+	Describe("Template functions", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/template_funcs.yaml")
+		})
+
+		It("transforms regex captures", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/wiki/My_Old_Page.html"}, nil), 308, "https://success.example/docs/my-old-page")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/wiki/MyOldPage.html"}, nil), 308, "https://success.example/docs/my-old-page")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/files/a%26b.pdf"}, nil), 308, "https://success.example/download?name=a%26b.pdf")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/Articles/intro.php"}, nil), 308, "https://success.example/articles/intro")
+		})
+
+		It("falls back to defaults for empty groups", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/old/About_Us"}, nil), 308, "https://success.example/new/about_us/home")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/old/About_Us/team"}, nil), 308, "https://success.example/new/about_us/team")
+		})
+
+		It("transforms path parameters", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/shop/HomeGarden/big%20chair"}, nil), 308, "https://success.example/store/home_garden/big-chair")
+		})
+
+		It("transforms the prefix remainder instead of appending it", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/Legacy/Some/Page"}, nil), 308, "https://success.example/modern/some/page")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "fn.example", Path: "/keep/Some"}, nil), 308, "https://success.example/kept/Some")
+		})
+
+		It("fails provision on invalid calls", func() {
+			for _, to := range []string{`/{lowr $1}`, `/{lower $1 $1}`, `/{lower $9}`, `/{trimSuffix ".html $1}`, `/{lower $rest}`, `/{lower x}`} {
+				r := &redir.Redirector{Hosts: []redir.HostBlock{
+					{Pattern: "bad.example", Regex: []redir.RegexRule{{Pattern: "^/(a)$", To: to}}},
+				}}
+				Expect(r.Provision(s.Context())).To(HaveOccurred(), to)
+			}
+		})
+	})
+
+	Describe("Weighted targets", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/split.yaml")
+		})
+
+		It("splits requests by weight and sets a sticky cookie", func() {
+			hits := map[string]int{}
+			for range 1000 {
+				resp := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/checkout"}, nil)
+				Expect(resp.Status()).To(Equal(302))
+				hits[resp.Location()]++
+
+				name := map[string]string{"/new-checkout": "new", "/checkout-v3": "v3"}[resp.Location()]
+				Expect(resp.Header("Set-Cookie")).To(HavePrefix("ab_checkout=" + name + ";"))
+				Expect(resp.Header("Vary")).To(Equal("Cookie"))
+			}
+			Expect(hits).To(HaveLen(2))
+			Expect(hits["/new-checkout"]).To(BeNumerically("~", 900, 80))
+		})
+
+		It("keeps the variant named by the cookie", func() {
+			for range 20 {
+				resp := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/checkout", Cookies: []*http.Cookie{{Name: "ab_checkout", Value: "v3"}}}, nil)
+				AssertRedirect(resp, 302, "/checkout-v3")
+				Expect(resp.Header("Set-Cookie")).To(BeEmpty())
+			}
+		})
+
+		It("sticks to a hash of the client IP or a header", func() {
+			first := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/ip", RemoteAddr: "192.0.2.7:1234"}, nil).Location()
+			Expect(first).To(BeElementOf("/a", "/b"))
+			for port := range 20 {
+				addr := "192.0.2.7:" + strconv.Itoa(2000+port)
+				AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/ip", RemoteAddr: addr}, nil), 307, first)
+			}
+
+			users := map[string]bool{}
+			for i := range 50 {
+				h := http.Header{"X-User": {"user-" + strconv.Itoa(i)}}
+				loc := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/shop/cart", Header: h}, nil).Location()
+				Expect(loc).To(BeElementOf("/store-a/cart", "/store-b/cart"))
+				resp := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/shop/cart", Header: h}, nil)
+				AssertRedirect(resp, 307, loc)
+				Expect(resp.Header("Vary")).To(Equal("X-User"))
+				users[loc] = true
+			}
+			Expect(users).To(HaveLen(2))
+		})
+
+		It("expands regex groups in every variant", func() {
+			loc := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/p/42", Header: http.Header{"X-User": {"u"}}}, nil).Location()
+			Expect(loc).To(BeElementOf("/product/42", "/item/42"))
+		})
+
+		It("exposes the variant as a placeholder", func() {
+			resp := s.RunOnce(r, &RequestSpec{Host: "ab.example", Path: "/tag", Header: http.Header{"X-User": {"u"}}}, NextPlaceholder{Key: "http.redirector.variant"})
+			variant := resp.Header("X-Next-Placeholder")
+			Expect(variant).To(BeElementOf("a", "b"))
+			Expect(resp.Header("X-Next-URI")).To(BeEmpty())
+		})
+
+		It("fails provision on invalid splits", func() {
+			for _, opts := range []redir.RuleOptions{
+				{Targets: []redir.WeightedTarget{{To: "/a", Weight: 0}}},
+				{Targets: []redir.WeightedTarget{{To: "/a", Weight: 1}}, Sticky: "session"},
+				{Sticky: "client_ip"},
+				{Targets: []redir.WeightedTarget{{To: "/a", Weight: 1}}, Action: "gone"},
+				{Targets: []redir.WeightedTarget{{To: "/a", Weight: 1}, {To: "/b", Weight: 1, Name: "/a"}}},
+				{Targets: []redir.WeightedTarget{{To: "/a", Weight: 1}}, Status: 301},
+				{Targets: []redir.WeightedTarget{{To: "/a", Weight: 1}}, Status: 307, PromoteAfter: "14d"},
+			} {
+				r := &redir.Redirector{Hosts: []redir.HostBlock{
+					{Pattern: "bad.example", ExactRules: []redir.ExactRule{{From: "/x", RuleOptions: opts}}},
+				}}
+				Expect(r.Provision(s.Context())).To(HaveOccurred(), "%+v", opts)
+			}
+
+			dup := &redir.Redirector{Hosts: []redir.HostBlock{{Pattern: "bad.example", Prefix: []redir.PrefixRule{{From: "/x/", RuleOptions: redir.RuleOptions{
+				Targets: []redir.WeightedTarget{{To: "/a/", Weight: 1, Name: "a"}, {To: "/b/", Weight: 1, Name: "a"}},
+			}}}}}}
+			Expect(dup.Validate()).To(MatchError(ContainSubstring(`name "a" is used by another target`)))
+
+			r := &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "bad.example", ExactRules: []redir.ExactRule{{From: "/x", To: "/y", RuleOptions: redir.RuleOptions{Targets: []redir.WeightedTarget{{To: "/a", Weight: 1}}}}}},
+			}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring("to and targets can't be combined")))
+		})
+	})
+
+	Describe("Time windows", func() {
+		var r *redir.Redirector
+		var now time.Time
+
+		at := func(ts string) {
+			var err error
+			now, err = time.Parse(time.RFC3339, ts)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			at("2026-08-15T12:00:00Z")
+			r = WithClock(&redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/schedule.yaml")}},
+			}, func() time.Time { return now })
+			Expect(r.Provision(s.Context())).To(Succeed())
+		})
+
+		get := func(host, path string) *Response {
+			return s.RunOnce(r, &RequestSpec{Host: host, Path: path}, nil)
+		}
+
+		It("activates and expires rules without a reload", func() {
+			AssertPassedThrough(get("shop.example", "/xmas"), 204)
+
+			at("2026-12-01T00:00:00Z")
+			AssertRedirect(get("shop.example", "/xmas"), 302, "/christmas-sale")
+
+			at("2026-12-26T22:59:59Z")
+			AssertRedirect(get("shop.example", "/xmas"), 302, "/christmas-sale")
+
+			at("2026-12-26T23:00:00Z")
+			Expect(get("shop.example", "/xmas").Status()).To(Equal(410))
+		})
+
+		It("falls through to the next candidate while a rule is inactive", func() {
+			AssertRedirect(get("shop.example", "/preview"), 308, "/coming-soon")
+			at("2026-11-01T09:00:00Z")
+			AssertRedirect(get("shop.example", "/preview"), 308, "/new-shop")
+		})
+
+		It("applies the expiry action", func() {
+			AssertRedirect(get("shop.example", "/summer"), 308, "/summer-sale")
+			AssertRedirect(get("shop.example", "/deal/7"), 308, "/deals/7")
+			AssertRedirect(get("shop.example", "/promo/shoes"), 308, "/campaign/shoes")
+
+			at("2026-10-01T00:00:00Z")
+			AssertRedirect(get("shop.example", "/summer"), 308, "/sale")
+			AssertRedirect(get("shop.example", "/deal/7"), 308, "/archive/7")
+			AssertPassedThrough(get("shop.example", "/promo/shoes"), 204)
+		})
+
+		It("limits host blocks to their window", func() {
+			AssertPassedThrough(get("old.example", "/about"), 204)
+			AssertRedirect(get("campaign.example", "/"), 308, "/landing")
+
+			at("2026-11-01T00:00:00Z")
+			AssertRedirect(get("old.example", "/about"), 308, "https://new.example/about")
+			AssertRedirect(get("campaign.example", "/"), 308, "https://shop.example/")
+			AssertRedirect(get("campaign.example", "/anything"), 308, "https://shop.example/")
+		})
+
+		It("accepts native TOML dates", func() {
+			r := WithClock(&redir.Redirector{
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/schedule.toml")}},
+			}, func() time.Time { return now })
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "toml.example", Path: "/launch"}, nil), 204)
+			at("2026-11-01T09:00:00Z")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "toml.example", Path: "/launch"}, nil), 308, "/v2")
+			at("2027-01-01T00:00:00Z")
+			Expect(s.RunOnce(r, &RequestSpec{Host: "toml.example", Path: "/launch"}, nil).Status()).To(Equal(404))
+		})
+
+		It("rejects invalid windows", func() {
+			for _, sched := range []redir.Schedule{
+				{ActiveFrom: "next week"},
+				{ActiveFrom: "2026-12-01", ActiveUntil: "2026-11-01"},
+				{Expired: "gone"},
+			} {
+				r := &redir.Redirector{Hosts: []redir.HostBlock{
+					{Pattern: "bad.example", ExactRules: []redir.ExactRule{{From: "/x", To: "/y", RuleOptions: redir.RuleOptions{Schedule: sched}}}},
+				}}
+				Expect(r.Validate()).To(HaveOccurred(), "%+v", sched)
+				Expect(r.Provision(s.Context())).To(HaveOccurred(), "%+v", sched)
+			}
+		})
+	})
+
+	Describe("Status promotion", func() {
+		var storage certmagic.Storage
+		var now time.Time
+
+		start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+		provision := func() *redir.Redirector {
+			GinkgoHelper()
+			r := WithStorage(WithClock(&redir.Redirector{
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/promote.yaml")}},
+			}, func() time.Time { return now }), storage)
+			Expect(r.Provision(s.Context())).To(Succeed())
+			return r
+		}
+
+		get := func(r *redir.Redirector, host, path string) *Response {
+			return s.RunOnce(r, &RequestSpec{Host: host, Path: path}, nil)
+		}
+
+		BeforeEach(func() {
+			storage = &certmagic.FileStorage{Path: GinkgoT().TempDir()}
+			now = start
+		})
+
+		It("switches to the permanent code after the duration", func() {
+			r := provision()
+			AssertRedirect(get(r, "migrate.example", "/old"), 307, "/new")
+			AssertRedirect(get(r, "migrate.example", "/found"), 302, "/found-new")
+			AssertRedirect(get(r, "dated.example", "/docs/x"), 302, "/manual/x")
+
+			now = start.Add(36 * time.Hour)
+			AssertRedirect(get(r, "dated.example", "/docs/x"), 301, "/manual/x")
+			AssertRedirect(get(r, "migrate.example", "/old"), 307, "/new")
+
+			now = start.Add(14 * 24 * time.Hour)
+			AssertRedirect(get(r, "migrate.example", "/old"), 308, "/new")
+			AssertRedirect(get(r, "migrate.example", "/found"), 301, "/found-new")
+			AssertRedirect(get(r, "migrate.example", "/fixed"), 301, "/fixed-new")
+		})
+
+		stored := func() []string {
+			keys, _ := storage.List(context.Background(), "redirector/promote", true)
+			return keys
+		}
+
+		It("counts from the first provision across restarts", func() {
+			get(provision(), "migrate.example", "/old")
+			Eventually(stored).ShouldNot(BeEmpty())
+
+			now = start.Add(10 * 24 * time.Hour)
+			r := provision()
+			AssertRedirect(get(r, "migrate.example", "/old"), 307, "/new")
+
+			now = start.Add(14 * 24 * time.Hour)
+			AssertRedirect(get(r, "migrate.example", "/old"), 308, "/new")
+		})
+
+		It("starts the clock only once a provisioned config serves", func() {
+			provision()
+			Consistently(stored, 50*time.Millisecond).Should(BeEmpty())
+
+			r := WithStorage(&redir.Redirector{
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/promote.yaml")}, {Path: ConfigPath("configs/promote.yaml")}},
+				Strict:     true,
+			}, storage)
+			Expect(r.Provision(s.Context())).To(HaveOccurred())
+			Expect(stored()).To(BeEmpty())
+
+			now = start.Add(10 * 24 * time.Hour)
+			r = provision()
+			AssertRedirect(get(r, "migrate.example", "/old"), 307, "/new")
+			now = start.Add(14 * 24 * time.Hour)
+			AssertRedirect(get(r, "migrate.example", "/old"), 307, "/new")
+		})
+
+		It("switches at a fixed date", func() {
+			r := provision()
+			AssertRedirect(get(r, "dated.example", "/a"), 307, "/b")
+			now = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+			AssertRedirect(get(r, "dated.example", "/a"), 308, "/b")
+		})
+
+		It("rejects invalid promotions", func() {
+			for _, opts := range []redir.RuleOptions{
+				{Status: 301, PromoteAfter: "14d"},
+				{Action: "gone", PromoteAfter: "14d"},
+				{Status: 307, PromoteAfter: "soon"},
+			} {
+				r := WithStorage(&redir.Redirector{Hosts: []redir.HostBlock{
+					{Pattern: "bad.example", ExactRules: []redir.ExactRule{{From: "/x", To: "/y", RuleOptions: opts}}},
+				}}, storage)
+				Expect(r.Provision(s.Context())).To(HaveOccurred(), "%+v", opts)
+			}
+
+			r := &redir.Redirector{Hosts: []redir.HostBlock{{Pattern: "bad.example", PromoteAfter: "-1d"}}}
+			Expect(r.Validate()).To(MatchError(ContainSubstring("promote_after must be positive")))
+		})
+	})
+
+	Describe("Locales", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/locale.yaml")
+		})
+
+		get := func(host, path, acceptLanguage string, cookies ...*http.Cookie) *Response {
+			req := &RequestSpec{Host: host, Path: path, Cookies: cookies}
+			if acceptLanguage != "" {
+				req.Header = http.Header{"Accept-Language": {acceptLanguage}}
+			}
+			return s.RunOnce(r, req, nil)
+		}
+
+		It("negotiates the locale from Accept-Language", func() {
+			resp := get("site.example", "/", "de-DE,de;q=0.9,en;q=0.8")
+			AssertRedirect(resp, 308, "/de/")
+			Expect(resp.Header("Vary")).To(Equal("Cookie, Accept-Language"))
+
+			AssertRedirect(get("site.example", "/", "en;q=0.5, fr;q=0.9"), 308, "/fr-CH/")
+			AssertRedirect(get("site.example", "/", "FR-ch"), 308, "/fr-CH/")
+			AssertRedirect(get("site.example", "/", "it, *;q=0.1"), 308, "/en/")
+			AssertRedirect(get("site.example", "/", "de;q=0, it"), 308, "/en/")
+			AssertRedirect(get("site.example", "/", ""), 308, "/en/")
+
+			resp = get("plain.example", "/", "de")
+			AssertRedirect(resp, 308, "/de/")
+			Expect(resp.Header("Vary")).To(Equal("Accept-Language"))
+		})
+
+		It("lets the cookie override the header", func() {
+			AssertRedirect(get("site.example", "/pricing", "en", &http.Cookie{Name: "lang", Value: "DE"}), 302, "/de/pricing")
+			AssertRedirect(get("site.example", "/pricing", "en", &http.Cookie{Name: "lang", Value: "xx"}), 302, "/en/pricing")
+		})
+
+		It("uses the locale in any part of a target", func() {
+			AssertRedirect(get("site.example", "/docs/intro", "fr"), 308, "https://docs.example/fr-CH/intro")
+			AssertRedirect(get("site.example", "/about", "de"), 308, "/de/about")
+		})
+
+		It("doesn't add a second locale to a path", func() {
+			for _, path := range []string{"/de/pricing", "/de", "/fr-ch/about"} {
+				resp := get("site.example", path, "en")
+				AssertPassedThrough(resp, 204)
+				Expect(resp.Header("Vary")).To(BeEmpty())
+			}
+			AssertRedirect(get("site.example", "/deals", "de"), 308, "/de/deals")
+		})
+
+		It("rejects invalid locale configs", func() {
+			for _, lc := range []*redir.LocaleConfig{
+				{},
+				{Locales: []string{"en", "de"}, Default: "fr"},
+				{Locales: []string{"en/us"}},
+			} {
+				r := &redir.Redirector{Hosts: []redir.HostBlock{{Pattern: "bad.example", Locale: lc}}}
+				Expect(r.Validate()).To(HaveOccurred(), "%+v", lc)
+				Expect(r.Provision(s.Context())).To(HaveOccurred(), "%+v", lc)
+			}
+		})
+	})
+
+	Describe("GeoIP conditions", func() {
+		var dbPath string
+
+		BeforeEach(func() {
+			dbPath = filepath.Join(GinkgoT().TempDir(), "country.mmdb")
+			WriteGeoDB(dbPath,
+				GeoNetwork{CIDR: "192.0.2.0/24", Country: "CH", Continent: "EU"},
+				GeoNetwork{CIDR: "198.51.100.0/24", Country: "DE", Continent: "EU"},
+				GeoNetwork{CIDR: "203.0.113.0/24", Country: "US", Continent: "NA"},
+			)
+		})
+
+		provision := func() *redir.Redirector {
+			GinkgoHelper()
+			r := &redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/geo.yaml")}},
+				GeoIP:       &redir.GeoIPConfig{Database: dbPath, CheckInterval: caddy.Duration(10 * time.Millisecond)},
+			}
+			Expect(r.Provision(s.Context())).To(Succeed())
+			return r
+		}
+
+		from := func(host, remoteAddr string) *RequestSpec {
+			return &RequestSpec{Host: host, Path: "/cart", RemoteAddr: remoteAddr}
+		}
+
+		It("matches on country and continent", func() {
+			r := provision()
+			AssertRedirect(s.RunOnce(r, from("shop.example", "198.51.100.7:4000"), nil), 308, "https://eu.shop.example/cart")
+			AssertRedirect(s.RunOnce(r, from("shop.example", "192.0.2.1:4000"), nil), 308, "https://ch.shop.example/cart")
+			AssertPassedThrough(s.RunOnce(r, from("shop.example", "203.0.113.9:4000"), NextOK{}), 204)
+			AssertPassedThrough(s.RunOnce(r, from("shop.example", "10.0.0.1:4000"), NextOK{}), 204)
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "us.example", Path: "/", RemoteAddr: "203.0.113.9:4000"}, nil), 308, "/en-us/")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "us.example", Path: "/", RemoteAddr: "198.51.100.7:4000"}, NextOK{}), 204)
+		})
+
+		It("uses the client IP behind trusted proxies", func() {
+			r := provision()
+			proxied := from("shop.example", "203.0.113.9:4000")
+			proxied.Vars = map[string]any{caddyhttp.ClientIPVarKey: "198.51.100.7"}
+			AssertRedirect(s.RunOnce(r, proxied, nil), 308, "https://eu.shop.example/cart")
+		})
+
+		It("reloads the database when the file changes", func() {
+			r := provision()
+			AssertRedirect(s.RunOnce(r, from("shop.example", "198.51.100.7:4000"), nil), 308, "https://eu.shop.example/cart")
+
+			WriteGeoDB(dbPath, GeoNetwork{CIDR: "198.51.100.0/24", Country: "US", Continent: "NA"})
+			later := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(dbPath, later, later)).To(Succeed())
+
+			Eventually(func() int {
+				return s.RunOnce(r, from("shop.example", "198.51.100.7:4000"), NextOK{}).Status()
+			}).Should(Equal(204))
+		})
+
+		It("rejects geo conditions without a usable database", func() {
+			r := &redir.Redirector{RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/geo.yaml")}}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring("needs a geoip database")))
+
+			r = &redir.Redirector{
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/geo.yaml")}},
+				GeoIP:      &redir.GeoIPConfig{Database: filepath.Join(GinkgoT().TempDir(), "missing.mmdb")},
+			}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring("missing.mmdb")))
+
+			r = &redir.Redirector{
+				GeoIP: &redir.GeoIPConfig{Database: dbPath},
+				Hosts: []redir.HostBlock{{Pattern: "bad.example", Match: redir.Conditions{"geo": map[string]any{}}}},
+			}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring("needs a country or continent")))
+		})
+	})
+
+	Describe("Request conditions", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorFromFiles(308, "configs/conditions.yaml")
+		})
+
+		It("falls through exact rules whose conditions fail", func() {
+			mobile := &RequestSpec{Host: "cond.example", Path: "/app", Header: http.Header{"User-Agent": {"Foo Mobile Safari"}}}
+			AssertRedirect(s.RunOnce(r, mobile, nil), 308, "https://success.example/mobile")
+
+			internal := &RequestSpec{Host: "cond.example", Path: "/app", RemoteAddr: "10.1.2.3:5555"}
+			AssertRedirect(s.RunOnce(r, internal, nil), 308, "https://success.example/internal")
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cond.example", Path: "/app"}, nil), 308, "https://success.example/desktop")
+		})
+
+		It("matches on method for prefix rules", func() {
+			post := &RequestSpec{Method: http.MethodPost, Host: "cond.example", Path: "/api/users"}
+			AssertRedirect(s.RunOnce(r, post, nil), 308, "https://success.example/v2/users")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cond.example", Path: "/api/users"}, nil), 308, "https://success.example/v1/users")
+		})
+
+		It("matches on cookies for path rules", func() {
+			beta := &RequestSpec{Host: "cond.example", Path: "/beta/x", Cookies: []*http.Cookie{{Name: "beta", Value: "1"}}}
+			AssertRedirect(s.RunOnce(r, beta, nil), 308, "https://success.example/preview/x")
+
+			other := &RequestSpec{Host: "cond.example", Path: "/beta/x", Cookies: []*http.Cookie{{Name: "beta", Value: "0"}}}
+			AssertPassedThrough(s.RunOnce(r, other, NextOK{}), 204)
+		})
+
+		It("matches on query and CEL expressions for regex rules", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cond.example", Path: "/search/go?lang=de"}, nil), 308, "https://success.example/find?q=go")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cond.example", Path: "/search/go"}, nil), 308, "https://success.example/en/find?q=go")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "cond.example", Path: "/search/go?lang=fr"}, NextOK{}), 204)
+		})
+
+		It("passes to next handler when host conditions fail", func() {
+			staff := &RequestSpec{Host: "staff.example", Path: "/", RemoteAddr: "192.168.1.10:4000"}
+			AssertRedirect(s.RunOnce(r, staff, nil), 308, "https://success.example/staff")
+
+			outside := &RequestSpec{Host: "staff.example", Path: "/", RemoteAddr: "203.0.113.9:4000"}
+			AssertPassedThrough(s.RunOnce(r, outside, NextOK{}), 204)
+		})
+
+		It("fails provision on an unknown matcher", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{{
+				Pattern:    "bad.example",
+				ExactRules: []redir.ExactRule{{From: "/a", To: "/b", RuleOptions: redir.RuleOptions{Match: redir.Conditions{"nope": true}}}},
+			}}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring(`match "nope"`)))
+		})
+	})
+
+	Describe("Redirect chains", func() {
+		build := func(flatten bool) *redir.Redirector {
+			r := &redir.Redirector{
+				DefaultCode:   308,
+				FlattenChains: flatten,
+				RulesFiles:    []redir.RulesFile{{Path: ConfigPath("configs/chains.yaml")}},
+			}
+			Expect(r.Provision(s.Context())).To(Succeed())
+			return r
+		}
+
+		It("keeps every hop unless flattening is enabled", func() {
+			r := build(false)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain.example", Path: "/a"}, nil), 308, "/b")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain.example", Path: "/x"}, nil), 308, "https://chain-new.example/y")
+		})
+
+		It("points chained rules at the final destination", func() {
+			r := build(true)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain.example", Path: "/a"}, nil), 308, "/c")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain.example", Path: "/x"}, nil), 308, "https://success.example/end?from=chain")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain-new.example", Path: "/y"}, nil), 308, "https://success.example/end?from=chain")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain-moved.example", Path: "/m", ForwardProto: "http"}, nil), 308, "http://chain-target.example/o")
+		})
+
+		It("stops at hops that depend on the request", func() {
+			r := build(true)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain.example", Path: "/q"}, nil), 308, "/cond")
+		})
+
+		It("flattens only hops with the status and query policy of the first rule", func() {
+			r := build(true)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain-query.example", Path: "/a?x=1"}, nil), 308, "/c?x=1")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain-query.example", Path: "/c?x=1"}, nil), 308, "/e")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "chain-query.example", Path: "/s"}, nil), 302, "/t")
+		})
+
+		It("fails provision on loops and names their rules", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "loop-a.example", Exact: map[string]string{"/start": "/a", "/a": "https://loop-b.example/b"}},
+				{Pattern: "loop-b.example", Exact: map[string]string{"/b": "https://loop-a.example/a"}},
+			}}
+			Expect(r.Provision(s.Context())).To(MatchError("redirect loop: loop-a.example/a -> loop-b.example/b -> loop-a.example/a"))
+
+			r = &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "self.example", Exact: map[string]string{"/same": "/same"}},
+			}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring("self.example/same -> self.example/same")))
+		})
+	})
+
+	Describe("Host matching", func() {
+		It("prefers exact host over wildcard over catch-all", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_wildcards.yaml")
+
+			resp1 := s.RunOnce(r, &RequestSpec{Host: "special.prec.example", Path: "/e"}, nil)
+			AssertRedirect(resp1, 308, "https://success.example/x")
+
+			resp2 := s.RunOnce(r, &RequestSpec{Host: "foo.prec.example", Path: "/e"}, nil)
+			AssertRedirect(resp2, 308, "https://wildcard.example/w")
+
+			resp3 := s.RunOnce(r, &RequestSpec{Host: "other.com", Path: "/ping"}, nil)
+			AssertRedirect(resp3, 308, "/health")
+		})
+
+		It("matches host case-insensitively", func() {
+			r := &redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/case.json")}},
+			}
+			Expect(r.Provision(caddy.Context{})).To(Succeed())
+			resp := NewSuite().RunOnce(r, &RequestSpec{Host: "TeSt.ExAmPlE", Path: "/old"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/new")
+		})
+
+		It("does not match wildcard against apex domain", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "*.wild.example", ToHost: "wild.example", Exact: map[string]string{"/p": "/q"}},
+				{Pattern: "wild.example", ToHost: "apex.example", Exact: map[string]string{"/p": "/q"}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "wild.example", Path: "/p"}, nil)
+			AssertRedirect(resp, 308, "https://apex.example/q")
+		})
+
+		It("prefers the most specific wildcard regardless of declaration order", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "*.example.com", ToHost: "broad.example", Exact: map[string]string{"/p": "/q"}},
+				{Pattern: "*.shop.example.com", ToHost: "shop.example", Exact: map[string]string{"/p": "/q"}},
+			})
+
+			resp1 := s.RunOnce(r, &RequestSpec{Host: "eu.shop.example.com", Path: "/p"}, nil)
+			AssertRedirect(resp1, 308, "https://shop.example/q")
+
+			resp2 := s.RunOnce(r, &RequestSpec{Host: "shop.example.com", Path: "/p"}, nil)
+			AssertRedirect(resp2, 308, "https://broad.example/q")
+		})
+
+		It("keeps the first block for a duplicated host pattern", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "dup.example", ToHost: "first.example", Exact: map[string]string{"/p": "/q"}},
+				{Pattern: "DUP.example", ToHost: "second.example", Exact: map[string]string{"/p": "/q"}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "dup.example", Path: "/p"}, nil)
+			AssertRedirect(resp, 308, "https://first.example/q")
+		})
+
+		It("resolves hosts among thousands of blocks", func() {
+			var hosts []redir.HostBlock
+			for i := 0; i < 5000; i++ {
+				n := strconv.Itoa(i)
+				hosts = append(hosts,
+					redir.HostBlock{Pattern: "site" + n + ".example", ToHost: "exact" + n + ".example", Exact: map[string]string{"/p": "/q"}},
+					redir.HostBlock{Pattern: "*.site" + n + ".example", ToHost: "wild" + n + ".example", Exact: map[string]string{"/p": "/q"}},
+				)
+			}
+			r := s.BuildRedirectorInline(308, hosts)
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "site4999.example", Path: "/p"}, nil), 308, "https://exact4999.example/q")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "a.b.site123.example", Path: "/p"}, nil), 308, "https://wild123.example/q")
+		})
+
+		It("passes to next handler when no host matches", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rules_exact.json")
+			resp := s.RunOnce(r, &RequestSpec{Host: "no-match.example", Path: "/anything"}, NextOK{})
+			AssertPassedThrough(resp, 204)
+		})
+	})
+
+	Describe("Cascading host blocks", func() {
+		build := func(cascade bool) *redir.Redirector {
+			r := &redir.Redirector{
+				Cascade:    cascade,
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/cascade.yaml")}},
+			}
+			Expect(r.Provision(s.Context())).To(Succeed())
+			return r
+		}
+
+		It("only consults the most specific block by default", func() {
+			r := build(false)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "app.cascade.example", Path: "/own"}, nil), 308, "/from-exact")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "app.cascade.example", Path: "/ping"}, NextOK{}), 204)
+		})
+
+		It("falls through from exact to wildcard to catch-all blocks", func() {
+			r := build(true)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "app.cascade.example", Path: "/own"}, nil), 308, "/from-exact")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "app.cascade.example", Path: "/wild"}, nil), 308, "/from-wildcard")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "app.cascade.example", Path: "/ping"}, nil), 308, "/pong")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "x.app.cascade.example", Path: "/wild"}, nil), 308, "/from-wildcard")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "x.app.cascade.example", Path: "/feed/atom"}, nil), 308, "/rss/atom")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "other.example", Path: "/ping"}, nil), 308, "/pong")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "app.cascade.example", Path: "/none"}, NextOK{}), 204)
+		})
+
+		It("stops at the first block with a matching rule", func() {
+			r := build(true)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "x.cascade.example", Path: "/shared"}, nil), 308, "/from-wildcard")
+		})
+
+		It("continues when the conditions of a block don't apply", func() {
+			r := build(true)
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "beta.cascade.example", Path: "/wild"}, nil), 308, "/from-wildcard")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "beta.cascade.example", Path: "/wild", Header: http.Header{"X-Beta": {"1"}}}, nil), 308, "/from-beta")
+		})
+	})
+
+	Describe("Regex host patterns", func() {
+		It("uses named host captures in to_host and targets", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/regex_host.yaml")
+
+			resp1 := s.RunOnce(r, &RequestSpec{Host: "acme.legacy.example", Path: "/"}, nil)
+			AssertRedirect(resp1, 308, "https://acme.app.example/dashboard")
+
+			resp2 := s.RunOnce(r, &RequestSpec{Host: "acme.legacy.example", Path: "/files/a.pdf"}, nil)
+			AssertRedirect(resp2, 308, "https://acme.app.example/tenants/acme/files/a.pdf")
+		})
+
+		It("uses numbered host captures next to path captures", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/regex_host.yaml")
+			resp := s.RunOnce(r, &RequestSpec{Host: "ACME.legacy.example:8080", Path: "/u/7"}, nil)
+			AssertRedirect(resp, 308, "https://acme.app.example/acme/users/7")
+		})
+
+		It("is tried after exact and wildcard hosts but before the catch-all", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "*", Exact: map[string]string{"/p": "/all"}},
+				{Pattern: `~^([a-z]+)\.re\.example$`, Exact: map[string]string{"/p": "/regex/{host.1}"}},
+				{Pattern: "*.wild.re.example", Exact: map[string]string{"/p": "/wild"}},
+				{Pattern: "exact.re.example", Exact: map[string]string{"/p": "/exact"}},
+			})
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "exact.re.example", Path: "/p"}, nil), 308, "/exact")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "a.wild.re.example", Path: "/p"}, nil), 308, "/wild")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "tenant.re.example", Path: "/p"}, nil), 308, "/regex/tenant")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "other.example", Path: "/p"}, nil), 308, "/all")
+		})
+
+		It("leaves unknown references untouched", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: `~^(?P<t>[a-z]+)\.x\.example$`, Exact: map[string]string{"/p": "/{t}/{other}/{host.9}"}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "a.x.example", Path: "/p"}, nil)
+			AssertRedirect(resp, 308, "/a/{other}/{host.9}")
+		})
+
+		It("fails provision on an invalid host regex", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{{Pattern: "~(", Exact: map[string]string{"/a": "/b"}}}}
+			Expect(r.Provision(caddy.Context{})).To(HaveOccurred())
+		})
+	})
+
+	Describe("Host normalization", func() {
+		var r *redir.Redirector
+
+		BeforeEach(func() {
+			r = s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "old.example", ToHost: "new.example", Exact: map[string]string{"/p": "/q"}},
+				{Pattern: "old.example:8443", ToHost: "tls.example", Exact: map[string]string{"/p": "/q"}},
+				{Pattern: "[2001:DB8::1]", ToHost: "v6.example", Exact: map[string]string{"/p": "/q"}},
+				{Pattern: "*.bücher.example", ToHost: "idn.example", Exact: map[string]string{"/p": "/q"}},
+			})
+		})
+
+		It("ignores the port unless the pattern names one", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "old.example:8080", Path: "/p"}, nil), 308, "https://new.example/q")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "old.example:8443", Path: "/p"}, nil), 308, "https://tls.example/q")
+		})
+
+		It("drops the trailing root dot", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "OLD.example.", Path: "/p"}, nil), 308, "https://new.example/q")
+		})
+
+		It("matches bracketed IPv6 literals in canonical form", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "[2001:db8:0::1]:8080", Path: "/p"}, nil), 308, "https://v6.example/q")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "[2001:db8::1]", Path: "/p"}, nil), 308, "https://v6.example/q")
+		})
+
+		It("matches Unicode and punycode spellings of the same name", func() {
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "shop.xn--bcher-kva.example", Path: "/p"}, nil), 308, "https://idn.example/q")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "shop.BÜCHER.example", Path: "/p"}, nil), 308, "https://idn.example/q")
+		})
+
+		It("merges rule file blocks that spell the host differently", func() {
+			m := &redir.Redirector{
+				DefaultCode: 308,
+				Hosts:       []redir.HostBlock{{Pattern: "xn--bcher-kva.example", Exact: map[string]string{"/a": "/inline"}}},
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/idn.yaml")}},
+			}
+			Expect(m.Provision(caddy.Context{})).To(Succeed())
+
+			AssertRedirect(s.RunOnce(m, &RequestSpec{Host: "bücher.example", Path: "/a"}, nil), 308, "https://books.example/inline")
+			AssertRedirect(s.RunOnce(m, &RequestSpec{Host: "xn--bcher-kva.example", Path: "/b"}, nil), 308, "https://books.example/from-file")
+		})
+	})
+
+	Describe("Rule file merging", func() {
+		It("uses last-wins strategy on conflicting keys", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/merge_a.json", "configs/merge_b.json")
+
+			resp := s.RunOnce(r, &RequestSpec{Host: "merge.example", Path: "/x"}, nil)
+			AssertRedirect(resp, 301, "https://a.example/z")
+
+			resp2 := s.RunOnce(r, &RequestSpec{Host: "merge.example", Path: "/a/123"}, nil)
+			AssertRedirect(resp2, 301, "https://a.example/b/123")
+		})
+	})
+
+	Describe("Status code resolution", func() {
+		It("host-level status overrides the global default", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "status.example", Status: 301, Exact: map[string]string{"/old": "/new"}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "status.example", Path: "/old"}, nil)
+			AssertRedirect(resp, 301, "/new")
+		})
+
+		It("rule-level status overrides the host status", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/rule_status.json")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "status.example", Path: "/moved"}, nil), 301, "/new")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "status.example", Path: "/campaign"}, nil), 302, "/summer-sale")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "status.example", Path: "/form/x"}, nil), 303, "/thanks/x")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "status.example", Path: "/tmp/x"}, nil), 307, "/maintenance/x")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "status.example", Path: "/p/1"}, nil), 308, "/posts/1")
+		})
+
+		It("fails provision on a status that is not a redirect", func() {
+			r := &redir.Redirector{RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/bad_status.yaml")}}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring("200 given")))
+
+			r = &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "bad.example", Status: 304, Exact: map[string]string{"/a": "/b"}},
+			}}
+			Expect(r.Provision(s.Context())).To(HaveOccurred())
+
+			r = &redir.Redirector{DefaultCode: 300}
+			Expect(r.Provision(s.Context())).To(HaveOccurred())
+		})
+	})
+
+	Describe("Scheme inference", func() {
+		It("defaults to https and respects X-Forwarded-Proto header", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/scheme.yaml")
+
+			resp1 := s.RunOnce(r, &RequestSpec{Host: "scheme.example", Path: "/h"}, nil)
+			AssertRedirect(resp1, 308, "https://success.example/h")
+
+			resp2 := s.RunOnce(r, &RequestSpec{Host: "scheme.example", Path: "/h", ForwardProto: "http"}, nil)
+			AssertRedirect(resp2, 308, "http://success.example/h")
+		})
+
+		It("prefers TLS connection state over X-Forwarded-Proto", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "tls.example", ToHost: "success.example", Exact: map[string]string{"/h": "/h"}},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "tls.example", Path: "/h", ForwardProto: "http", UseTLS: true}, nil)
+			AssertRedirect(resp, 308, "https://success.example/h")
+		})
+	})
+
+	Describe("Rule type precedence", func() {
+		It("exact takes priority over prefix, which takes priority over regex", func() {
+			r := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{
+					Pattern: "prec.example",
+					ToHost:  "success.example",
+					Exact:   map[string]string{"/a": "/E"},
+					Prefix:  []redir.PrefixRule{{From: "/a", To: "/P"}},
+					Regex:   []redir.RegexRule{{Pattern: "^/a$", To: "/R"}},
+				},
+			})
+			resp := s.RunOnce(r, &RequestSpec{Host: "prec.example", Path: "/a"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/E")
+		})
+
+		It("follows the order of a host block", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/priority.yaml")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "order.example", Path: "/docs/v2/intro"}, nil), 308, "https://success.example/regex/v2")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "order.example", Path: "/docs/intro"}, nil), 308, "https://success.example/prefix/intro")
+		})
+
+		It("tries rules with a higher priority first, whatever their type", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/priority.yaml")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "prio.example", Path: "/blog/2020/x"}, nil), 308, "https://success.example/archive/2020")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Method: http.MethodPost, Host: "prio.example", Path: "/blog/2020/x"}, nil), 308, "https://success.example/exact")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Method: http.MethodPost, Host: "prio.example", Path: "/blog/2021/a"}, nil), 308, "https://success.example/news/2021/a")
+		})
+
+		It("fails provision on an unknown rule type in order", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "bad.example", Order: []string{"regex", "glob"}, Exact: map[string]string{"/a": "/b"}},
+			}}
+			Expect(r.Provision(s.Context())).To(MatchError(ContainSubstring(`unknown rule type "glob"`)))
+		})
+	})
+
+	Describe("Error handling", func() {
+		It("fails provision on bad regex", func() {
+			r := &redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/bad_regex.yaml")}},
+			}
+			Expect(r.Provision(caddy.Context{})).To(HaveOccurred())
+		})
+
+		It("fails provision on unknown file format", func() {
+			r := &redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/unknown.data")}},
+			}
+			Expect(r.Provision(caddy.Context{})).To(HaveOccurred())
+		})
+
+		It("reports every problem of a configuration at once", func() {
+			r := &redir.Redirector{
+				Hosts:      []redir.HostBlock{{Pattern: "inline.example", ToHost: "new example"}},
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/invalid.yaml")}},
+			}
+			file := fmt.Sprintf("rule_file %q: ", ConfigPath("configs/invalid.yaml"))
+
+			for _, err := range []error{r.Validate(), r.Provision(s.Context())} {
+				Expect(err).To(HaveOccurred())
+				msg := err.Error()
+				for _, want := range []string{
+					`host "inline.example": to_host "new example": must be a host with an optional port`,
+					file + `host #1: empty pattern`,
+					file + `host "invalid.example": to_host "https://new.example": must not include a scheme`,
+					file + `host "invalid.example": status must be 301, 302, 303, 307 or 308, 200 given`,
+					file + `host "invalid.example": exact "old": from must start with /`,
+					file + `host "invalid.example": prefix "docs/": from must start with /`,
+					file + `host "invalid.example": prefix "docs/": target "/v2/%zz" doesn't parse as a URL`,
+					file + `host "invalid.example": regex "^/u/(\\d+)$": target "/users/$2" refers to unknown group "2"`,
+					`refers to unknown group "name"`,
+					`refers to unknown group "1x"`,
+					file + `host "port.example": to_host "new.example:99999": bad port "99999"`,
+				} {
+					Expect(msg).To(ContainSubstring(want))
+				}
+				Expect(msg).NotTo(ContainSubstring(`unknown group "slug"`))
+				Expect(msg).NotTo(ContainSubstring(`unknown group "1"`))
+			}
+		})
+
+		It("validates a correct configuration", func() {
+			r := &redir.Redirector{RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/template_funcs.yaml")}}}
+			Expect(r.Validate()).To(Succeed())
+		})
+
+		It("fails provision on missing file", func() {
+			r := &redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/does_not_exist.json")}},
+			}
+			Expect(r.Provision(caddy.Context{})).To(HaveOccurred())
+		})
+	})
+
+	Describe("Rule lint", func() {
+		build := func(strict bool) *redir.Redirector {
+			return &redir.Redirector{
+				Strict: strict,
+				Hosts: []redir.HostBlock{
+					{Pattern: "lint.example", Exact: map[string]string{"/a": "/x"}},
+					{Pattern: "*.lint.example", Exact: map[string]string{"/w": "/x"}},
+					{Pattern: "*.LINT.example.", Exact: map[string]string{"/w": "/z"}},
+				},
+				RulesFiles: []redir.RulesFile{{Path: ConfigPath("configs/lint.yaml")}},
+			}
+		}
+
+		It("only warns by default", func() {
+			r := build(false)
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "lint.example", Path: "/dup"}, nil), 308, "/one")
+		})
+
+		It("fails provision in strict mode and lists every unreachable rule", func() {
+			err := build(true).Provision(s.Context())
+			Expect(err).To(HaveOccurred())
+			msg := err.Error()
+			for _, want := range []string{
+				fmt.Sprintf(`rule_file %q: host "lint.example": exact "/a" replaces target "/x" of an earlier source with "/y"`, ConfigPath("configs/lint.yaml")),
+				`host "*.LINT.example." is hidden by host "*.lint.example" declared before it`,
+				`host "lint.example": exact "/dup" is never used`,
+				`host "lint.example": exact "/q?id=1" is never used`,
+				`host "lint.example": prefix "/docs/" is never used`,
+				`host "lint.example": regex "^/docs/(.*)$" is never used, prefix "/docs/" matches every path it does`,
+				`host "lint.example": regex "^/u/(\\d+)$" is never used, another regex with the same pattern always applies first`,
+			} {
+				Expect(msg).To(ContainSubstring(want))
+			}
+			Expect(msg).NotTo(ContainSubstring(`"/cond"`))
+		})
+	})
+
+	Describe("Instance isolation", func() {
+		It("keeps rules of separately provisioned instances apart", func() {
+			a := s.BuildRedirectorInline(308, []redir.HostBlock{
+				{Pattern: "iso.example", ToHost: "a.example", Exact: map[string]string{"/x": "/a"}},
+			})
+			b := s.BuildRedirectorInline(301, []redir.HostBlock{
+				{Pattern: "iso.example", ToHost: "b.example", Exact: map[string]string{"/x": "/b"}},
+			})
+
+			AssertRedirect(s.RunOnce(a, &RequestSpec{Host: "iso.example", Path: "/x"}, nil), 308, "https://a.example/a")
+			AssertRedirect(s.RunOnce(b, &RequestSpec{Host: "iso.example", Path: "/x"}, nil), 301, "https://b.example/b")
+		})
+
+		It("passes to next handler before provisioning", func() {
+			r := &redir.Redirector{Hosts: []redir.HostBlock{
+				{Pattern: "iso.example", Exact: map[string]string{"/x": "/y"}},
+			}}
+			resp := s.RunOnce(r, &RequestSpec{Host: "iso.example", Path: "/x"}, NextOK{})
+			AssertPassedThrough(resp, 204)
+		})
+
+		It("does not merge rule files twice when provisioned again", func() {
+			r := s.BuildRedirectorFromFiles(308, "configs/merge_a.json", "configs/merge_b.json")
+			Expect(r.Provision(caddy.Context{})).To(Succeed())
+
+			Expect(r.Hosts).To(BeEmpty())
+			resp := s.RunOnce(r, &RequestSpec{Host: "merge.example", Path: "/a/123"}, nil)
+			AssertRedirect(resp, 301, "https://a.example/b/123")
+		})
+
+		It("serves consistently while instances are re-provisioned concurrently", func() {
+			hosts := func(to string) []redir.HostBlock {
+				return []redir.HostBlock{{Pattern: "iso.example", Exact: map[string]string{"/x": to}}}
+			}
+			a := s.BuildRedirectorInline(308, hosts("/a"))
+			b := s.BuildRedirectorInline(308, hosts("/b"))
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(2)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						Expect(a.Provision(caddy.Context{})).To(Succeed())
+						Expect(b.Provision(caddy.Context{})).To(Succeed())
+					}
+				}()
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						AssertRedirect(s.RunOnce(a, &RequestSpec{Host: "iso.example", Path: "/x"}, nil), 308, "/a")
+						AssertRedirect(s.RunOnce(b, &RequestSpec{Host: "iso.example", Path: "/x"}, nil), 308, "/b")
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	})
+
+	Describe("Caddyfile", func() {
+		It("parses path template rules", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					to_host success.example
+					path /blog/{year:int}/{slug} /articles/{slug}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(caddy.Context{})).To(Succeed())
+
+			resp := s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/blog/2023/x"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/articles/x")
+		})
+
+		It("parses query-aware exact and prefix rules", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					exact /index.php?id=42 /articles/42
+					exact /search.php?q=go /search/go {
+						ignore_extra_query
+					}
+					prefix /list.php?cat=3 /category/3
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/index.php?id=42"}, nil), 308, "/articles/42")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/search.php?q=go&page=3"}, nil), 308, "/search/go")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/list.php?cat=3"}, nil), 308, "/category/3")
+		})
+
+		It("parses query policies on all levels", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				query preserve
+				host cf.example {
+					query merge {
+						remove utm_*
+						rename p=page
+					}
+					exact /a /b?page=1
+					exact /c /d {
+						query {
+							add ref=cf src=x
+						}
+					}
+				}
+				host other.example {
+					exact /a /b
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a?p=2&utm_x=1&q=1"}, nil), 308, "/b?page=1&q=1")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/c?q=1"}, nil), 308, "/d?ref=cf&src=x")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "other.example", Path: "/a?q=1"}, nil), 308, "/b?q=1")
+		})
+
+		It("parses flatten_chains", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				flatten_chains
+				host cf.example {
+					exact /a /b
+					exact /b /c
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.FlattenChains).To(BeTrue())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a"}, nil), 308, "/c")
+		})
+
+		It("parses order and priority", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					order regex prefix
+					prefix /a/ /p/
+					regex ^/a/(.*)$ /r/$1
+					exact /a/x /e {
+						priority 5
+					}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Hosts[0].Order).To(Equal([]string{"regex", "prefix"}))
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a/y"}, nil), 308, "/r/y")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a/x"}, nil), 308, "/e")
+		})
+
+		It("parses cascade", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				cascade
+				host * {
+					exact /ping /pong
+				}
+				host cf.example {
+					exact /a /b
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Cascade).To(BeTrue())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/ping"}, nil), 308, "/pong")
+		})
+
+		It("parses weighted targets", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					exact /checkout {
+						target /new-checkout 90 new
+						target /checkout-v3 10
+						sticky cookie ab
+					}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Hosts[0].ExactRules[0].Targets).To(Equal([]redir.WeightedTarget{
+				{To: "/new-checkout", Weight: 90, Name: "new"},
+				{To: "/checkout-v3", Weight: 10},
+			}))
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			resp := s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/checkout", Cookies: []*http.Cookie{{Name: "ab", Value: "/checkout-v3"}}}, nil)
+			AssertRedirect(resp, 307, "/checkout-v3")
+		})
+
+		It("parses time windows", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					active_until 2030-01-01
+					expired gone
+					exact /sale /offers {
+						active_from 2026-12-01T00:00:00+01:00
+						active_until 2026-12-27
+						expired /
+					}
+				}
+			}`)
+			r := WithClock(&redir.Redirector{}, func() time.Time { return time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC) })
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Hosts[0].Schedule).To(Equal(redir.Schedule{ActiveUntil: "2030-01-01", Expired: "gone"}))
+			Expect(r.Hosts[0].ExactRules[0].Schedule).To(Equal(redir.Schedule{
+				ActiveFrom: "2026-12-01T00:00:00+01:00", ActiveUntil: "2026-12-27", Expired: "/",
+			}))
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/sale"}, nil), 308, "/")
+		})
+
+		It("parses promote_after", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					status 307
+					promote_after 14d
+					exact /a /b {
+						status 302
+						promote_after 2026-11-01
+					}
+				}
+			}`)
+			r := WithStorage(WithClock(&redir.Redirector{}, func() time.Time { return time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC) }),
+				&certmagic.FileStorage{Path: GinkgoT().TempDir()})
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Hosts[0].PromoteAfter).To(Equal(redir.Timestamp("14d")))
+			Expect(r.Hosts[0].ExactRules[0].PromoteAfter).To(Equal(redir.Timestamp("2026-11-01")))
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a"}, nil), 301, "/b")
+		})
+
+		It("parses locale configs", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					locale en de {
+						default de
+						cookie lang
+					}
+					exact / /{locale}/
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Hosts[0].Locale).To(Equal(&redir.LocaleConfig{Locales: []string{"en", "de"}, Default: "de", Cookie: "lang"}))
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/"}, nil), 308, "/de/")
+		})
+
+		It("parses geoip and geo conditions", func() {
+			dbPath := filepath.Join(GinkgoT().TempDir(), "country.mmdb")
+			WriteGeoDB(dbPath, GeoNetwork{CIDR: "198.51.100.0/24", Country: "DE", Continent: "EU"})
+			d := caddyfile.NewTestDispenser(fmt.Sprintf(`redirector {
+				geoip %s {
+					check_interval 5m
+				}
+				host cf.example {
+					exact / /eu/ {
+						match geo continent eu
+					}
+					exact / /us/ {
+						match {
+							geo country US CA
+						}
+					}
+				}
+			}`, dbPath))
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.GeoIP).To(Equal(&redir.GeoIPConfig{Database: dbPath, CheckInterval: caddy.Duration(5 * time.Minute)}))
+			Expect(r.Provision(s.Context())).To(Succeed())
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/", RemoteAddr: "198.51.100.7:4000"}, nil), 308, "/eu/")
+		})
+
+		It("parses rule-level status codes", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				status 301
+				host cf.example {
+					exact /a /b
+					prefix /c/ /d/ {
+						status 302
+					}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a"}, nil), 301, "/b")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/c/x"}, nil), 302, "/d/x")
+
+			d = caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					exact /a /b {
+						status 304
+					}
+				}
+			}`)
+			Expect((&redir.Redirector{}).UnmarshalCaddyfile(d)).To(MatchError(ContainSubstring("304 given")))
+		})
+
+		It("parses response actions without a target", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					exact /old {
+						action gone
+						body "Removed for good"
+						content_type text/plain
+					}
+					prefix /legal/ {
+						action unavailable_for_legal_reasons
+					}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			resp := s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/old"}, nil)
+			Expect(resp.Status()).To(Equal(410))
+			Expect(resp.Body()).To(Equal("Removed for good"))
+			Expect(resp.Header("Content-Type")).To(Equal("text/plain"))
+			Expect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/legal/x"}, nil).Status()).To(Equal(451))
+
+			d = caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					exact /old
+				}
+			}`)
+			Expect((&redir.Redirector{}).UnmarshalCaddyfile(d)).To(MatchError(ContainSubstring("needs a target")))
+		})
+
+		It("parses the rewrite action on hosts and rules", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					action rewrite
+					exact /a /b
+					exact /c /d {
+						action redirect
+					}
+				}
+				host other.example {
+					prefix /x/ /y/ {
+						action rewrite
+					}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a"}, NextURI{}), "/b")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/c"}, NextURI{}), 308, "/d")
+			AssertRewritten(s.RunOnce(r, &RequestSpec{Host: "other.example", Path: "/x/1"}, NextURI{}), "/y/1")
+		})
+
+		It("parses template functions in quoted targets", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					regex ^/wiki/(\w+)$ "/docs/{kebab $1}"
+					regex ^/p/(\w*)$ ` + "`/q/{default $1 \"home\"}`" + `
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/wiki/Big_Idea"}, nil), 308, "/docs/big-idea")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/p/"}, nil), 308, "/q/home")
+		})
+
+		It("keeps the declaration order of exact rules for the same path", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					exact /a /plain
+					exact /a /beta {
+						match cookie beta 1
+					}
+					exact /b /beta {
+						match cookie beta 1
+					}
+					exact /b /plain
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			beta := []*http.Cookie{{Name: "beta", Value: "1"}}
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a", Cookies: beta}, nil), 308, "/plain")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/b", Cookies: beta}, nil), 308, "/beta")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/b"}, nil), 308, "/plain")
+		})
+
+		It("parses match conditions on hosts and rules", func() {
+			d := caddyfile.NewTestDispenser(`redirector {
+				host cf.example {
+					to_host success.example
+					match header X-Env prod
+					exact /a /mobile {
+						match {
+							header User-Agent *Mobile*
+							cookie beta 1
+						}
+					}
+					exact /a /desktop
+					prefix /api/ /v2/ {
+						match ` + "`{http.request.method} == 'POST'`" + `
+					}
+				}
+			}`)
+			r := &redir.Redirector{}
+			Expect(r.UnmarshalCaddyfile(d)).To(Succeed())
+			Expect(r.Provision(s.Context())).To(Succeed())
+
+			prod := http.Header{"X-Env": {"prod"}}
+			mobile := &RequestSpec{Host: "cf.example", Path: "/a", Cookies: []*http.Cookie{{Name: "beta", Value: "1"}},
+				Header: http.Header{"X-Env": {"prod"}, "User-Agent": {"Mobile"}}}
+			AssertRedirect(s.RunOnce(r, mobile, nil), 308, "https://success.example/mobile")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a", Header: prod}, nil), 308, "https://success.example/desktop")
+			AssertRedirect(s.RunOnce(r, &RequestSpec{Method: http.MethodPost, Host: "cf.example", Path: "/api/x", Header: prod}, nil), 308, "https://success.example/v2/x")
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/api/x", Header: prod}, NextOK{}), 204)
+			AssertPassedThrough(s.RunOnce(r, &RequestSpec{Host: "cf.example", Path: "/a"}, NextOK{}), 204)
+		})
+	})
+
+	Describe("Explicit format override", func() {
+		It("succeeds for extension-less files with an explicit format", func() {
+			r := &redir.Redirector{
+				DefaultCode: 308,
+				RulesFiles:  []redir.RulesFile{{Path: ConfigPath("configs/noext"), Format: "json"}},
+			}
+			Expect(r.Provision(caddy.Context{})).To(Succeed())
+			resp := NewSuite().RunOnce(r, &RequestSpec{Host: "noext.example", Path: "/old"}, nil)
+			AssertRedirect(resp, 308, "https://success.example/new")
+		})
+	})
+})
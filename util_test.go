@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector_test
+
+import (
+	"context"
+	"crypto/tls"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func ProjectRoot() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		Fail("cannot determine caller path")
+	}
+	// file is <module root>/util_test.go
+	return filepath.Dir(file)
+}
+
+func ConfigPath(rel string) string {
+	return filepath.Join(ProjectRoot(), "tests", rel)
+}
+
+type RequestSpec struct {
+	Method       string
+	Host         string
+	Path         string
+	ForwardProto string
+	UseTLS       bool
+	Header       http.Header
+	RemoteAddr   string
+	Cookies      []*http.Cookie
+	Vars         map[string]any
+}
+
+func (r *RequestSpec) Build() *http.Request {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	url := "http://" + r.Host + r.Path
+	req := httptest.NewRequest(r.Method, url, nil)
+	req.Host = r.Host
+	if r.Header != nil {
+		for k, vv := range r.Header {
+			for _, v := range vv {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	if r.ForwardProto != "" {
+		req.Header.Set("X-Forwarded-Proto", r.ForwardProto)
+	}
+	if r.UseTLS {
+		req.TLS = &tls.ConnectionState{}
+	}
+	if strings.EqualFold(r.ForwardProto, "http") {
+		req.Header.Set("X-Forwarded-Proto", "http")
+	}
+	for _, c := range r.Cookies {
+		req.AddCookie(c)
+	}
+	if r.RemoteAddr != "" {
+		req.RemoteAddr = r.RemoteAddr
+	}
+
+	// Mirror what Caddy's server sets up before handlers run, so matcher
+	// modules like client_ip and expression work in unit tests.
+	clientIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+	vars := map[string]any{caddyhttp.ClientIPVarKey: clientIP}
+	maps.Copy(vars, r.Vars)
+	req = req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, vars))
+	caddyhttp.NewTestReplacer(req)
+	return req
+}
+
+type recorder struct {
+	h      http.Header
+	code   int
+	wroteH bool
+	body   []byte
+}
+
+func newRecorder() *recorder { return &recorder{h: make(http.Header)} }
+
+func (r *recorder) Header() http.Header { return r.h }
+func (r *recorder) Write(p []byte) (int, error) {
+	if !r.wroteH {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body = append(r.body, p...)
+	return len(p), nil
+}
+func (r *recorder) WriteHeader(statusCode int) { r.wroteH, r.code = true, statusCode }
+
+type Response struct{ Recorder *recorder }
+
+func (r *Response) Status() int              { return r.Recorder.code }
+func (r *Response) Header(key string) string { return r.Recorder.h.Get(key) }
+func (r *Response) Location() string         { return r.Header("Location") }
+func (r *Response) Body() string             { return string(r.Recorder.body) }
+
+type NextOK struct{}
+
+func (NextOK) ServeHTTP(w http.ResponseWriter, _ *http.Request) error {
+	w.Header().Set("X-Next", "hit")
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// NextURI answers 204 and echoes the request URI it received, to observe
+// rewrites.
+type NextURI struct{}
+
+func (NextURI) ServeHTTP(w http.ResponseWriter, req *http.Request) error {
+	w.Header().Set("X-Next", "hit")
+	w.Header().Set("X-Next-URI", req.URL.RequestURI())
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// NextPlaceholder answers 204 and echoes a placeholder of the request's
+// replacer, to observe values handlers set for logging.
+type NextPlaceholder struct{ Key string }
+
+func (n NextPlaceholder) ServeHTTP(w http.ResponseWriter, req *http.Request) error {
+	repl := req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	v, _ := repl.GetString(n.Key)
+	w.Header().Set("X-Next", "hit")
+	w.Header().Set("X-Next-Placeholder", v)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type NextCapture struct{}
+
+func (NextCapture) ServeHTTP(w http.ResponseWriter, _ *http.Request) error {
+	w.Header().Set("X-Next", "hit")
+	_, _ = w.Write([]byte("next"))
+	return nil
+}
+
+func AssertRedirect(resp *Response, wantStatus int, wantLoc string) {
+	GinkgoHelper()
+	Expect(resp.Status()).To(Equal(wantStatus), "status mismatch")
+	Expect(resp.Location()).To(Equal(wantLoc), "location mismatch")
+}
+
+func AssertRewritten(resp *Response, wantURI string) {
+	GinkgoHelper()
+	Expect(resp.Header("X-Next")).To(Equal("hit"), "expected request to pass to next handler")
+	Expect(resp.Header("X-Next-URI")).To(Equal(wantURI), "rewritten URI mismatch")
+	Expect(resp.Location()).To(BeEmpty())
+}
+
+func AssertPassedThrough(resp *Response, wantStatus int) {
+	GinkgoHelper()
+	Expect(resp.Header("X-Next")).To(Equal("hit"), "expected request to pass to next handler")
+	Expect(resp.Status()).To(Equal(wantStatus), "unexpected next status")
+}
+
+// GeoNetwork is a network of a generated MaxMind DB fixture.
+type GeoNetwork struct {
+	CIDR      string
+	Country   string
+	Continent string
+}
+
+// WriteGeoDB writes a minimal IPv4 MaxMind DB with the country and continent
+// records of a GeoLite2 Country database.
+func WriteGeoDB(path string, networks ...GeoNetwork) {
+	GinkgoHelper()
+
+	ctrl := func(typ, size int) []byte {
+		if typ <= 7 {
+			return []byte{byte(typ<<5 | size)}
+		}
+		return []byte{byte(size), byte(typ - 7)}
+	}
+	str := func(s string) []byte { return append(ctrl(2, len(s)), s...) }
+	unsigned := func(typ int, v uint64) []byte {
+		var b []byte
+		for ; v > 0; v >>= 8 {
+			b = append([]byte{byte(v)}, b...)
+		}
+		return append(ctrl(typ, len(b)), b...)
+	}
+	mapOf := func(kv ...[]byte) []byte {
+		out := ctrl(7, len(kv)/2)
+		for _, b := range kv {
+			out = append(out, b...)
+		}
+		return out
+	}
+
+	// Node 0 is the root, so a child index of 0 means none; data holds the
+	// offset of a record plus one.
+	type node struct{ child, data [2]int }
+	nodes := []node{{}}
+	var data []byte
+	for _, gn := range networks {
+		_, ipnet, err := net.ParseCIDR(gn.CIDR)
+		Expect(err).NotTo(HaveOccurred())
+		ip := ipnet.IP.To4()
+		ones, _ := ipnet.Mask.Size()
+		bit := func(i int) int { return int(ip[i/8]>>(7-i%8)) & 1 }
+
+		n := 0
+		for i := range ones - 1 {
+			if nodes[n].child[bit(i)] == 0 {
+				nodes = append(nodes, node{})
+				nodes[n].child[bit(i)] = len(nodes) - 1
+			}
+			n = nodes[n].child[bit(i)]
+		}
+		nodes[n].data[bit(ones-1)] = len(data) + 1
+		data = append(data, mapOf(
+			str("continent"), mapOf(str("code"), str(gn.Continent)),
+			str("country"), mapOf(str("iso_code"), str(gn.Country)),
+		)...)
+	}
+
+	var out []byte
+	count := len(nodes)
+	for _, n := range nodes {
+		for b := range 2 {
+			v := count
+			switch {
+			case n.child[b] > 0:
+				v = n.child[b]
+			case n.data[b] > 0:
+				v = count + 16 + n.data[b] - 1
+			}
+			out = append(out, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, data...)
+	out = append(out, "\xAB\xCD\xEFMaxMind.com"...)
+	out = append(out, mapOf(
+		str("binary_format_major_version"), unsigned(5, 2),
+		str("binary_format_minor_version"), unsigned(5, 0),
+		str("build_epoch"), unsigned(9, 1),
+		str("database_type"), str("GeoLite2-Country"),
+		str("description"), mapOf(str("en"), str("test fixture")),
+		str("ip_version"), unsigned(5, 4),
+		str("languages"), append(ctrl(11, 1), str("en")...),
+		str("node_count"), unsigned(6, uint64(count)),
+		str("record_size"), unsigned(5, 24),
+	)...)
+	Expect(os.WriteFile(path, out, 0o644)).To(Succeed())
+}
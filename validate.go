@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: MIT
+// Copyright (c) 2025-2026 Jason Giese (Bl4cky99)
+
+package redirector
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// validator collects the problems of host blocks that can be found without
+// compiling them, so a configuration is rejected with all of them at once.
+type validator struct {
+	where string // source of the blocks, e.g. `rule_file "a.yaml": `
+	errs  []error
+}
+
+func (v *validator) errorf(host, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(v.where+host+": "+format, args...))
+}
+
+func (v *validator) hosts(hosts []HostBlock) {
+	for i, hb := range hosts {
+		v.host(i, hb)
+	}
+}
+
+func (v *validator) host(i int, hb HostBlock) {
+	host := fmt.Sprintf("host %q", hb.Pattern)
+	if strings.TrimSpace(hb.Pattern) == "" {
+		host = fmt.Sprintf("host #%d", i+1)
+		v.errorf(host, "empty pattern")
+	}
+
+	if hb.Status != 0 {
+		if err := checkRedirectCode(hb.Status); err != nil {
+			v.errorf(host, "%v", err)
+		}
+	}
+	if _, err := parseOrder(hb.Order); err != nil {
+		v.errorf(host, "%v", err)
+	}
+	if _, _, err := parseWindow(hb.Schedule); err != nil {
+		v.errorf(host, "%v", err)
+	}
+	if hb.PromoteAfter != "" {
+		if _, _, err := parsePromoteAfter(hb.PromoteAfter); err != nil {
+			v.errorf(host, "%v", err)
+		}
+	}
+	if to := hb.expiredTarget(); to != "" {
+		if _, err := url.Parse(templateRefs.ReplaceAllString(to, "x")); err != nil {
+			v.errorf(host, "expired target %q doesn't parse as a URL: %v", to, urlError(err))
+		}
+	}
+	if hb.Locale != nil {
+		if err := checkLocale(hb.Locale); err != nil {
+			v.errorf(host, "%v", err)
+		}
+	}
+	if hb.ToHost != "" {
+		if err := checkToHost(hb.ToHost); err != nil {
+			v.errorf(host, "to_host %q: %v", hb.ToHost, err)
+		}
+	}
+
+	for _, er := range hb.ExactRules {
+		v.rule(host, "exact", er.From, er.To, er.RuleOptions, true)
+	}
+	for _, from := range slices.Sorted(maps.Keys(hb.Exact)) {
+		v.rule(host, "exact", from, hb.Exact[from], RuleOptions{}, true)
+	}
+	for _, pr := range hb.Prefix {
+		v.rule(host, "prefix", pr.From, pr.To, pr.RuleOptions, true)
+	}
+	for _, pr := range hb.Path {
+		if _, _, err := parsePathTemplate(pr.Pattern); err != nil {
+			v.errorf(host, "%v", err)
+		}
+		v.rule(host, "path", pr.Pattern, pr.To, pr.RuleOptions, false)
+	}
+	for _, rr := range hb.Regex {
+		v.rule(host, "regex", rr.Pattern, rr.To, rr.RuleOptions, false)
+		re, err := regexp.Compile(rr.Pattern)
+		if err != nil {
+			v.errorf(host, "regex %q: %v", rr.Pattern, err)
+			continue
+		}
+		for _, to := range ruleTargets(rr.To, rr.RuleOptions) {
+			for _, ref := range unknownGroups(re, to) {
+				v.errorf(host, "regex %q: target %q refers to unknown group %q", rr.Pattern, to, ref)
+			}
+		}
+	}
+}
+
+// rule checks what all rule types share. slash is set for rule types whose
+// from is a literal path.
+func (v *validator) rule(host, kind, from, to string, opts RuleOptions, slash bool) {
+	if slash && !strings.HasPrefix(from, "/") {
+		v.errorf(host, "%s %q: from must start with /", kind, from)
+	}
+	if opts.Status != 0 {
+		if err := checkRedirectCode(opts.Status); err != nil {
+			v.errorf(host, "%s %q: %v", kind, from, err)
+		}
+	}
+	if len(opts.Targets) > 0 {
+		if err := checkTargets(opts.Targets); err != nil {
+			v.errorf(host, "%s %q: %v", kind, from, err)
+		}
+		if err := checkSplitStatus(opts.Status); err != nil {
+			v.errorf(host, "%s %q: %v", kind, from, err)
+		}
+	}
+	if _, _, err := parseWindow(opts.Schedule); err != nil {
+		v.errorf(host, "%s %q: %v", kind, from, err)
+	}
+	if opts.PromoteAfter != "" {
+		if _, _, err := parsePromoteAfter(opts.PromoteAfter); err != nil {
+			v.errorf(host, "%s %q: %v", kind, from, err)
+		}
+	}
+	for _, to := range ruleTargets(to, opts) {
+		if _, err := url.Parse(templateRefs.ReplaceAllString(to, "x")); err != nil {
+			v.errorf(host, "%s %q: target %q doesn't parse as a URL: %v", kind, from, to, urlError(err))
+		}
+	}
+}
+
+// ruleTargets returns To, the weighted targets and the expiry target of a
+// rule.
+func ruleTargets(to string, opts RuleOptions) []string {
+	var out []string
+	if to != "" {
+		out = append(out, to)
+	}
+	for _, wt := range opts.Targets {
+		out = append(out, wt.To)
+	}
+	if exp := opts.expiredTarget(); exp != "" {
+		out = append(out, exp)
+	}
+	return out
+}
+
+// templateRefs matches template references and calls, which are replaced by
+// plain text before a target is parsed as a URL.
+var templateRefs = regexp.MustCompile(`\{[^{}]*\}|\$\{?\w+\}?`)
+
+// urlError strips the repeated URL from a *url.Error.
+func urlError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
+
+// checkToHost accepts a host with an optional port. Templates are allowed in
+// both parts; a templated port is not checked.
+func checkToHost(s string) error {
+	if strings.Contains(s, "://") {
+		return fmt.Errorf("must not include a scheme")
+	}
+	if strings.ContainsAny(s, " \t\r\n/?#") {
+		return fmt.Errorf("must be a host with an optional port")
+	}
+
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return fmt.Errorf("unclosed [")
+		}
+		host, port = s[1:end], s[end+1:]
+		if port != "" {
+			var ok bool
+			if port, ok = strings.CutPrefix(port, ":"); !ok {
+				return fmt.Errorf("unexpected %q after ]", port)
+			}
+		}
+	} else if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		if strings.IndexByte(s[:i], ':') >= 0 {
+			return fmt.Errorf("IPv6 addresses must be in brackets")
+		}
+		host, port = s[:i], s[i+1:]
+	}
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if port != "" && !strings.Contains(port, "{") {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("bad port %q", port)
+		}
+	}
+	return nil
+}
+
+// unknownGroups returns the $N, $name and ${name} references of a regex
+// target, outside of template calls, that re has no group for.
+func unknownGroups(re *regexp.Regexp, to string) []string {
+	var refs []string
+	for i := 0; i < len(to); i++ {
+		switch {
+		case to[i] == '{' && (i == 0 || to[i-1] != '$'):
+			if end := strings.IndexByte(to[i:], '}'); end > 0 {
+				i += end
+			}
+			continue
+		case to[i] != '$' || i+1 == len(to):
+			continue
+		case to[i+1] == '$':
+			i++
+			continue
+		}
+
+		name := to[i+1:]
+		if rest, ok := strings.CutPrefix(name, "{"); ok {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				continue
+			}
+			name = rest[:end]
+			i += len(name) + 2
+		} else {
+			if end := strings.IndexFunc(name, func(r rune) bool { return !isWordRune(r) }); end >= 0 {
+				name = name[:end]
+			}
+			i += len(name)
+		}
+		if name == "" {
+			continue
+		}
+
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > re.NumSubexp() {
+				refs = append(refs, name)
+			}
+		} else if re.SubexpIndex(name) < 0 {
+			refs = append(refs, name)
+		}
+	}
+	return refs
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}